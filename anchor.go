@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// zeroAnchor is the anchor available even when none are configured.
+const zeroAnchor = "0"
+
+// Anchor is a quick-access session that is not tied to a discovered project.
+type Anchor struct {
+	// Name is the name of the session. It defaults to the anchor's name, so
+	// that e.g. the zero anchor opens a session named "0".
+	Name string `json:"name,omitempty"`
+	// Dir is the session's directory. It defaults to the home directory.
+	Dir string `json:"dir,omitempty"`
+	// Template is applied when the session is created.
+	Template string `json:"template,omitempty"`
+}
+
+// lookupAnchor returns the anchor with the given name. The zero anchor,
+// rooted in the home directory, exists unless it is configured otherwise.
+func lookupAnchor(config Config, name string) (Anchor, bool) {
+	if anchor, ok := config.Anchors[name]; ok {
+		return anchor, true
+	}
+
+	return Anchor{}, name == zeroAnchor
+}
+
+// handleAnchor switches to an anchor's session, creating it if necessary.
+func handleAnchor(config Config, name string, anchor Anchor) error {
+	id, _, err := ensureAnchor(config, name, anchor)
+	if err != nil {
+		return err
+	}
+
+	return switchToSession(config, id)
+}
+
+// ensureAnchor creates an anchor's session if it does not exist and returns
+// its ID and whether it was created.
+func ensureAnchor(config Config, name string, anchor Anchor) (string, bool, error) {
+	if anchor.Name != "" {
+		name = anchor.Name
+	}
+
+	id := cleanID(config, name)
+	if sessionExists(id) {
+		return id, false, nil
+	}
+
+	targetDir := expandHome(anchor.Dir)
+	if targetDir == "" {
+		var err error
+		targetDir, err = os.UserHomeDir()
+		if err != nil {
+			return "", false, err
+		}
+	}
+
+	var t Template
+	if anchor.Template != "" {
+		var err error
+		t, err = lookupTemplate(config, anchor.Template)
+		if err != nil {
+			return "", false, err
+		}
+	}
+
+	err := createTemplateSession(config, id, targetDir, t)
+	if err != nil {
+		return "", false, err
+	}
+
+	return id, true, nil
+}
+
+// handleAnchors prints the name and directory of every anchor, e.g. for shell
+// completions.
+func handleAnchors(config Config) error {
+	names := []string{}
+	for name := range config.Anchors {
+		names = append(names, name)
+	}
+	if _, ok := config.Anchors[zeroAnchor]; !ok {
+		names = append(names, zeroAnchor)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		anchor, _ := lookupAnchor(config, name)
+
+		dir := anchor.Dir
+		if dir == "" {
+			dir = "~"
+		}
+		fmt.Fprintf(stdIO.Stdout, "%s\t%s\n", name, dir)
+	}
+
+	return nil
+}
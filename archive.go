@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+)
+
+// handleArchive archives a project, hiding it from the picker and from
+// listings without deleting anything, or unarchives it again. Archived
+// projects are listed with --archived and are left out of bootstrap and
+// resume.
+func handleArchive(config Config, args []string, archive bool) error {
+	if len(args) != 1 {
+		if archive {
+			return errors.New("tsm: archive requires a project name or path")
+		}
+		return errors.New("tsm: unarchive requires a project name or path")
+	}
+
+	// Archived projects are only matched by name among archived ones.
+	config.showArchived = !archive
+	dir, err := resolveProject(config, args[0])
+	if err != nil {
+		return err
+	}
+	dir = path.Clean(dir)
+
+	return updateState(func(state *State) error {
+		i := slices.IndexFunc(state.Archived, func(p string) bool { return canonicalPath(p) == canonicalPath(dir) })
+		switch {
+		case archive && i >= 0:
+			return fmt.Errorf("tsm: %s is already archived", dir)
+		case archive:
+			state.Archived = append(state.Archived, dir)
+		case i < 0:
+			return fmt.Errorf("tsm: %s is not archived", dir)
+		default:
+			state.Archived = slices.Delete(state.Archived, i, i+1)
+		}
+
+		return nil
+	})
+}
+
+// readArchived returns the archived project directories.
+func readArchived() pathSet {
+	archived := pathSet{}
+
+	// Without a state, nothing is archived.
+	state, _ := loadState()
+	for _, p := range state.Archived {
+		archived.add(p)
+	}
+
+	return archived
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// handleBootstrap creates the sessions of every configured anchor and pinned
+// project that is not running, e.g. from .tmux.conf when the server starts.
+func handleBootstrap(config Config, args []string) error {
+	if len(args) > 0 {
+		return errors.New("tsm: bootstrap takes no arguments")
+	}
+
+	created, err := bootstrapSessions(config)
+	for _, id := range created {
+		fmt.Fprintf(stdIO.Stdout, "Created session %q\n", id)
+	}
+
+	return err
+}
+
+// bootstrapSessions creates the missing sessions of anchors and pins and
+// returns their IDs. Conflicting sessions cannot be resolved without a
+// terminal, so the fail policy is used unless another non-prompting policy is
+// configured. A session that cannot be created does not stop the others.
+func bootstrapSessions(config Config) ([]string, error) {
+	if config.OnConflict == "" || config.OnConflict == ConflictPrompt {
+		config.OnConflict = ConflictFail
+	}
+
+	var created []string
+	failed := 0
+	fail := func(err error) {
+		fmt.Fprintln(stdIO.Stderr, err)
+		failed++
+	}
+
+	names := make([]string, 0, len(config.Anchors))
+	for name := range config.Anchors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		id, ok, err := ensureAnchor(config, name, config.Anchors[name])
+		if err != nil {
+			fail(err)
+		} else if ok {
+			created = append(created, id)
+		}
+	}
+
+	state, err := loadState()
+	if err != nil {
+		return created, err
+	}
+
+	archived := readArchived()
+	for _, pin := range state.Pins {
+		// Only pinned directories have sessions of their own.
+		if !strings.HasPrefix(pin, "/") || archived.has(pin) {
+			continue
+		}
+
+		if _, ok := findSessionForPath(pin); ok {
+			continue
+		}
+
+		id, err := ensureSession(config, pin)
+		if err != nil {
+			fail(err)
+		} else if id != "" {
+			created = append(created, id)
+		}
+	}
+
+	if failed > 0 {
+		return created, fmt.Errorf("tsm: could not create %s", plural(failed, "session", "sessions"))
+	}
+
+	return created, nil
+}
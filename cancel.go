@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// runContext is cancelled when tsm is interrupted or terminated. Commands are
+// started with it, and discovery and enrichment stop once it is done.
+var runContext = context.Background()
+
+// errInterrupted makes tsm exit with the status of a process interrupted by
+// Ctrl-C.
+var errInterrupted = exitCodeError{code: 130}
+
+// interruptGrace is how long cancelled work may take to wind down after an
+// interrupt before tsm exits anyway. Reads of a hung network mount cannot be
+// cancelled, for example.
+const interruptGrace = time.Second
+
+// cancelOnInterrupt makes SIGINT and SIGTERM cancel runContext.
+func cancelOnInterrupt() {
+	// The context is only ever cancelled by a signal, so that commands
+	// started in the background outlive tsm.
+	ctx, cancel := context.WithCancel(context.Background())
+	runContext = ctx
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+
+		time.Sleep(interruptGrace)
+		os.Exit(errInterrupted.code)
+	}()
+}
+
+// Operations that are given up on after a timeout, which can be configured
+// separately in timeouts.
+const (
+	// TimeoutDiscovery bounds opening a base dir and each read of its
+	// entries.
+	TimeoutDiscovery = "discovery"
+	// TimeoutGit bounds each git command run for the indicators of a
+	// repository.
+	TimeoutGit = "git"
+	// TimeoutSSH bounds listing the sessions of a remote.
+	TimeoutSSH = "ssh"
+	// TimeoutGH bounds listing pull requests with gh.
+	TimeoutGH = "gh"
+)
+
+// defaultTimeouts bound each operation unless timeouts sets another.
+var defaultTimeouts = map[string]time.Duration{
+	TimeoutDiscovery: 10 * time.Second,
+	TimeoutGit:       5 * time.Second,
+	TimeoutSSH:       10 * time.Second,
+	TimeoutGH:        10 * time.Second,
+}
+
+// checkTimeouts reports timeouts, including those of the preview, that are
+// not durations or name no operation.
+func checkTimeouts(config Config) error {
+	err := checkTimeoutSet("timeouts", config.Timeouts, defaultTimeouts)
+	if err == nil && config.Picker.Preview != nil {
+		err = checkTimeoutSet("picker.preview.timeouts", config.Picker.Preview.Timeouts, defaultPreviewTimeouts)
+	}
+
+	return err
+}
+
+func checkTimeoutSet(setting string, timeouts map[string]string, defaults map[string]time.Duration) error {
+	for op, timeout := range timeouts {
+		if _, ok := defaults[op]; !ok {
+			return fmt.Errorf("tsm: unknown timeout %q in %s", op, setting)
+		}
+
+		_, err := time.ParseDuration(timeout)
+		if err != nil {
+			return fmt.Errorf("tsm: invalid timeout for %s in %s: %w", op, setting, err)
+		}
+	}
+
+	return nil
+}
+
+// operationTimeout returns how long op may take. Timeouts were checked by
+// checkTimeouts.
+func operationTimeout(config Config, op string) time.Duration {
+	if d, err := time.ParseDuration(config.Timeouts[op]); err == nil {
+		return d
+	}
+
+	return defaultTimeouts[op]
+}
+
+// timeoutContext returns a context for op that is done after its timeout or
+// once tsm is interrupted.
+func timeoutContext(config Config, op string) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(runContext, operationTimeout(config, op))
+}
+
+// awaitContext runs f and returns its result, or the context's error as soon
+// as ctx is done. f is left running in that case, as blocking system calls
+// cannot be interrupted.
+func awaitContext[T any](ctx context.Context, f func() (T, error)) (T, error) {
+	type result struct {
+		value T
+		err   error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		value, err := f()
+		done <- result{value, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
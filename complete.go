@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+)
+
+func getProjectCachePath() (string, error) {
+	return getCachePath("projects.json")
+}
+
+// writeProjectCache remembers the project directories found by a complete
+// walk for path-complete. The cache is only rewritten when it changes.
+func writeProjectCache(dirs []string) error {
+	cachePath, err := getProjectCachePath()
+	if err != nil {
+		return err
+	}
+
+	d, err := json.Marshal(dirs)
+	if err != nil {
+		return err
+	}
+
+	if f, err := os.ReadFile(cachePath); err == nil && bytes.Equal(f, d) {
+		return nil
+	}
+
+	err = os.MkdirAll(path.Dir(cachePath), 0700)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(cachePath, d, 0600)
+}
+
+// handlePathComplete prints the cached project directories whose names match
+// a partial name, best matches first, for shell completion and prompt
+// segments. It neither reads the config nor discovers projects or talks to
+// tmux, so that it returns in a few milliseconds. The cache is refreshed
+// whenever the picker or another command lists all projects.
+func handlePathComplete(args []string) error {
+	var partial string
+	if len(args) > 0 {
+		partial = args[0]
+	}
+
+	cachePath, err := getProjectCachePath()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.ReadFile(cachePath)
+	if err != nil {
+		// Nothing was discovered yet.
+		return nil
+	}
+
+	var dirs []string
+	err = json.Unmarshal(f, &dirs)
+	if err != nil {
+		return nil
+	}
+
+	matches := make([][]string, matchNone)
+	for _, dir := range dirs {
+		match := matchFuzzy
+		if partial != "" {
+			match = matchName(partial, path.Base(dir))
+		}
+
+		if match != matchNone {
+			matches[match] = append(matches[match], dir)
+		}
+	}
+
+	for _, dirs := range matches {
+		for _, dir := range dirs {
+			fmt.Fprintln(stdIO.Stdout, dir)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// sourcedValue is a setting of the config annotated with the file it was
+// read from.
+type sourcedValue struct {
+	value  any
+	source string
+}
+
+// handleDiffConfig prints every value set by the config file, its includes,
+// the overrides for this host, and the shared templates after layering them,
+// each followed by the file it came from. Settings left out of the files keep
+// their defaults and are not printed. With --json, the merged config is
+// printed instead, including the defaults.
+func handleDiffConfig(configPath string, config Config, args []string) error {
+	flags := flag.NewFlagSet("diff-config", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "")
+	flags.Parse(args)
+
+	if flags.NArg() > 0 {
+		return errors.New("tsm: diff-config takes no arguments")
+	}
+
+	if *asJSON {
+		d, err := json.MarshalIndent(config, "", "    ")
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(stdIO.Stdout, "%s\n", d)
+		return err
+	}
+
+	doc, err := loadSourcedConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	printSourcedValue(stdIO.Stdout, "", doc)
+	return nil
+}
+
+// loadSourcedConfig merges the config like loadConfig, but keeps the document
+// with every value wrapped in a sourcedValue.
+func loadSourcedConfig(configPath string) (map[string]any, error) {
+	doc, err := loadConfigLayers(configPath, nil, markConfigSource)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(doc, schemaKey)
+	err = applyHostOverrides(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	// Templates that fail to load were already reported by loadConfig.
+	templates, _ := doc["templates"].(map[string]any)
+	_ = walkSharedTemplates(configPath, func(name, p string) error {
+		if _, ok := templates[name]; ok {
+			return nil
+		}
+
+		_, err := readSharedTemplate(p)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		var t any
+		err = json.Unmarshal(f, &t)
+		if err != nil {
+			return err
+		}
+
+		if templates == nil {
+			templates = map[string]any{}
+			doc["templates"] = templates
+		}
+		templates[name] = sourceValue(t, p)
+		return nil
+	})
+
+	return doc, nil
+}
+
+// markConfigSource wraps the values of a config file's settings, with the
+// overrides for each host marked as such.
+func markConfigSource(layer map[string]any, configPath string) {
+	for key, value := range layer {
+		if key != hostsKey {
+			layer[key] = sourceValue(value, configPath)
+			continue
+		}
+
+		hosts, _ := value.(map[string]any)
+		for host, overrides := range hosts {
+			hosts[host] = sourceValue(overrides, fmt.Sprintf("%s, %s.%s", configPath, hostsKey, host))
+		}
+	}
+}
+
+// sourceValue wraps the values in v. Lists and objects are left as they are,
+// so that they are merged as before.
+func sourceValue(v any, source string) any {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case map[string]any:
+		for k, value := range v {
+			v[k] = sourceValue(value, source)
+		}
+		return v
+	case []any:
+		for i, value := range v {
+			v[i] = sourceValue(value, source)
+		}
+		return v
+	default:
+		return sourcedValue{value: v, source: source}
+	}
+}
+
+// printSourcedValue prints a line for each value in v, e.g.
+// `picker.git[0] = "branch"  (/home/me/.config/tsm/config.json)`. Empty lists
+// and objects hold no values and are left out.
+func printSourcedValue(w io.Writer, location string, v any) {
+	switch v := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			printSourcedValue(w, joinLocation(location, k), v[k])
+		}
+	case []any:
+		for i, value := range v {
+			printSourcedValue(w, fmt.Sprintf("%s[%d]", location, i), value)
+		}
+	case sourcedValue:
+		d, _ := json.Marshal(v.value)
+		fmt.Fprintf(w, "%s = %s  (%s)\n", location, d, v.source)
+	}
+}
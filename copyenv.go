@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// defaultCopyEnv are the variables copy-env refreshes unless copy_env is set.
+// They change with every SSH connection or graphical login.
+var defaultCopyEnv = []string{"SSH_AUTH_SOCK", "SSH_CONNECTION", "DISPLAY", "WAYLAND_DISPLAY", "XAUTHORITY"}
+
+// handleCopyEnv copies the allowed variables from the environment tsm runs in
+// into a session, the current one by default, so that windows created later
+// see them. Variables missing from the environment are removed from the
+// session. With --panes, idle shells in the session's panes are updated too.
+func handleCopyEnv(config Config, args []string) error {
+	flags := flag.NewFlagSet("copy-env", flag.ExitOnError)
+	panes := flags.Bool("panes", false, "")
+	flags.Parse(args)
+
+	id, err := sessionArg(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	if !sessionExists(id) {
+		return fmt.Errorf("tsm: session %q does not exist", id)
+	}
+
+	names := config.CopyEnv
+	if len(names) == 0 {
+		names = defaultCopyEnv
+	}
+
+	for _, name := range names {
+		command := []string{"tmux", "set-environment", "-t", id, "-r", name}
+		if value, ok := os.LookupEnv(name); ok {
+			command = []string{"tmux", "set-environment", "-t", id, name, value}
+		}
+
+		err = runCommand(IO{}, command...)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !*panes {
+		return nil
+	}
+
+	out, err := runCommandOutput("tmux", "list-panes", "-s", "-t", id,
+		"-F", tmuxFormat("#{pane_id}", "#{pane_current_command}"))
+	if err != nil {
+		return err
+	}
+
+	updated := 0
+	for _, line := range splitLines(out) {
+		paneID, command, ok := strings.Cut(line, fieldSep)
+		if !ok || !isShell(command) {
+			continue
+		}
+
+		err = runCommand(IO{}, "tmux", "send-keys", "-t", paneID, envCommand(command, names), "Enter")
+		if err != nil {
+			return err
+		}
+		updated++
+	}
+
+	fmt.Fprintf(stdIO.Stdout, "Updated %s\n", plural(updated, "idle shell", "idle shells"))
+
+	return nil
+}
+
+// envCommand returns the command that sets the variables to their values in
+// tsm's environment, and unsets missing ones, in the given shell.
+func envCommand(shell string, names []string) string {
+	commands := make([]string, 0, len(names))
+	for _, name := range names {
+		value, ok := os.LookupEnv(name)
+		switch {
+		case shell == "fish" && ok:
+			commands = append(commands, fmt.Sprintf("set -gx %s %s", name, shellQuote(value)))
+		case shell == "fish":
+			commands = append(commands, "set -e "+name)
+		case ok:
+			commands = append(commands, fmt.Sprintf("export %s=%s", name, shellQuote(value)))
+		default:
+			commands = append(commands, "unset "+name)
+		}
+	}
+
+	return strings.Join(commands, "; ")
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+type CreateConfig struct {
+	// GitInit runs git init in every new project.
+	GitInit bool `json:"git_init,omitempty"`
+	// Scaffold is a shell command run in every new project, after git init.
+	// The project's name and directory are in $TSM_NAME and $TSM_PATH.
+	Scaffold string `json:"scaffold,omitempty"`
+}
+
+// handleCreate creates a project directory under a base dir and switches to
+// its session.
+func handleCreate(config Config, args []string) error {
+	flags := flag.NewFlagSet("create", flag.ExitOnError)
+	baseDir := flags.String("base", "", "")
+	flags.Parse(args)
+
+	if flags.NArg() > 1 {
+		return errors.New("tsm: create takes at most one project name")
+	}
+
+	dir, err := createProject(config, *baseDir, flags.Arg(0))
+	if err != nil || dir == "" {
+		return err
+	}
+
+	if config.printTarget {
+		return printTargetDir(dir)
+	}
+
+	return switchToProject(config, dir)
+}
+
+// createProject makes a new project directory named name under baseDir,
+// asking for whichever of them is empty, and sets it up as configured in
+// create. The directory is returned, or an empty one if no name is given.
+func createProject(config Config, baseDir, name string) (string, error) {
+	var err error
+	if name == "" {
+		name, err = prompt("New project name: ")
+		if err != nil || name == "" {
+			return "", err
+		}
+	}
+	if name == "." || name == ".." || strings.ContainsRune(name, '/') {
+		return "", fmt.Errorf("tsm: invalid project name %q", name)
+	}
+
+	if baseDir == "" {
+		baseDir, err = chooseBaseDir(config)
+		if err != nil {
+			return "", err
+		}
+	}
+	baseDir, err = filepath.Abs(expandHome(baseDir))
+	if err != nil {
+		return "", err
+	}
+
+	dir := path.Join(baseDir, name)
+	err = os.Mkdir(dir, 0755)
+	if errors.Is(err, os.ErrExist) {
+		return "", fmt.Errorf("tsm: %s already exists", dir)
+	} else if err != nil {
+		return "", err
+	}
+
+	inOut := IO{Stdin: stdIO.Stdin, Stdout: stdIO.Stderr, Stderr: stdIO.Stderr}
+	if config.Create.GitInit {
+		err = runCommand(inOut, "git", "init", "--quiet", dir)
+		if err != nil {
+			return "", fmt.Errorf("tsm: git init in %s: %w", dir, err)
+		}
+	}
+
+	if config.Create.Scaffold != "" {
+		cmd := newCommand(inOut, "sh", "-c", config.Create.Scaffold)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "TSM_NAME="+name, "TSM_PATH="+dir)
+
+		// The directory is kept, so that a failed scaffold can be finished
+		// by hand.
+		err = cmd.Run()
+		if err != nil {
+			return "", fmt.Errorf("tsm: scaffold of %s failed: %w", dir, err)
+		}
+	}
+
+	return dir, nil
+}
+
+// chooseBaseDir returns the base dir a new project is created in. The only
+// base dir is used right away, while several are offered in a picker.
+func chooseBaseDir(config Config) (string, error) {
+	var baseDirs []string
+	for _, baseDir := range config.BaseDirs {
+		baseDir = expandHome(baseDir)
+		if !slices.Contains(baseDirs, baseDir) {
+			baseDirs = append(baseDirs, baseDir)
+		}
+	}
+
+	switch len(baseDirs) {
+	case 0:
+		return "", errors.New("tsm: no base dir to create the project in; set base_dirs or pass --base")
+	case 1:
+		return baseDirs[0], nil
+	}
+
+	out, err := runFzf(strings.NewReader(strings.Join(baseDirs, "\n")+"\n"),
+		"--prompt", "base dir> ", "--height", "40%", "--reverse")
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(out), nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// defaultEditorWindow is the name of the editor window of projects whose
+// template does not run the editor.
+const defaultEditorWindow = "editor"
+
+// handleEdit switches to a project's session and focuses its editor window,
+// the current session's by default.
+func handleEdit(config Config, args []string) error {
+	if len(args) > 1 {
+		return errors.New("tsm: edit accepts at most one project")
+	}
+
+	var targetDir string
+	var err error
+	if len(args) == 1 {
+		targetDir, err = resolveProject(config, args[0])
+	} else {
+		var id string
+		id, err = currentSession()
+		if err != nil {
+			return err
+		}
+		targetDir, err = sessionPath(id)
+	}
+	if err != nil {
+		return err
+	}
+
+	return editProject(config, targetDir)
+}
+
+// editProject switches to the session of a project directory with its editor
+// window selected. The window is created if it does not exist, and the editor
+// is started in it if the window only runs a shell.
+func editProject(config Config, targetDir string) error {
+	id, err := ensureSession(config, targetDir)
+	if err != nil {
+		return err
+	} else if id == "" {
+		return nil
+	}
+
+	editor := editorCommand(config)
+	name := editorWindow(config, targetDir, editor)
+
+	out, err := runCommandOutput("tmux", "list-windows", "-t", id,
+		"-F", tmuxFormat("#{window_id}", "#{window_name}", "#{pane_current_command}"))
+	if err != nil {
+		return err
+	}
+
+	var windowID string
+	running := false
+	for _, line := range splitLines(out) {
+		fields := strings.Split(line, fieldSep)
+		if len(fields) == 3 && fields[1] == name {
+			windowID = fields[0]
+			running = !isShell(fields[2])
+			break
+		}
+	}
+
+	if windowID == "" {
+		out, err := runCommandOutput("tmux", "new-window", "-d", "-t", id+":", "-n", name,
+			"-c", targetDir, "-P", "-F", "#{window_id}")
+		if err != nil {
+			return err
+		}
+		windowID = strings.TrimSpace(out)
+	}
+
+	// The editor is typed into the shell so that quitting it leaves the
+	// window open, as with template commands.
+	if !running {
+		err = runCommand(IO{}, "tmux", "send-keys", "-t", windowID, editor, "Enter")
+		if err != nil {
+			return err
+		}
+	}
+
+	err = runCommand(IO{}, "tmux", "select-window", "-t", windowID)
+	if err != nil {
+		return err
+	}
+
+	return switchToSession(config, id)
+}
+
+// editorCommand returns the configured editor, falling back to $VISUAL,
+// $EDITOR, and vi.
+func editorCommand(config Config) string {
+	for _, editor := range []string{config.Editor, os.Getenv("VISUAL"), os.Getenv("EDITOR")} {
+		if editor != "" {
+			return editor
+		}
+	}
+
+	return "vi"
+}
+
+// editorWindow returns the name of a project's editor window, which is the
+// first window of its template running the editor, if any.
+func editorWindow(config Config, targetDir, editor string) string {
+	templateName := projectTemplate(config, targetDir)
+	if templateName == "" {
+		return defaultEditorWindow
+	}
+
+	t, err := lookupTemplate(config, templateName)
+	if err != nil {
+		return defaultEditorWindow
+	}
+
+	program := commandProgram(editor)
+	for _, w := range t.Windows {
+		if w.Name != "" && commandProgram(w.Command) == program {
+			return w.Name
+		}
+	}
+
+	return defaultEditorWindow
+}
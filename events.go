@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// Types of events recorded in the event log.
+const (
+	EventCreated  = "created"
+	EventSwitched = "switched"
+	EventKilled   = "killed"
+	// EventPruned follows the killed event of a session removed by
+	// up --prune.
+	EventPruned = "pruned"
+)
+
+// maxEventsSize is the size at which the event log is rotated. The previous
+// log is kept with a ".1" suffix.
+const maxEventsSize = 1 << 20
+
+// eventPollInterval is how often events --follow checks for new events.
+const eventPollInterval = 250 * time.Millisecond
+
+type Event struct {
+	Type    string    `json:"type"`
+	Session string    `json:"session"`
+	Path    string    `json:"path,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+func getEventsPath() (string, error) {
+	return getStatePath("events.jsonl")
+}
+
+// recordEvent appends an event to the event log. Events are best effort and
+// never fail the operation that caused them.
+func recordEvent(eventType, id, dir string) {
+	_ = appendEvent(Event{Type: eventType, Session: id, Path: dir, Time: time.Now()})
+}
+
+func appendEvent(event Event) error {
+	eventsPath, err := getEventsPath()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(path.Dir(eventsPath), 0700)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(eventsPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if info, err := os.Stat(eventsPath); err == nil && info.Size() >= maxEventsSize {
+		err = os.Rename(eventsPath, eventsPath+".1")
+		if err != nil {
+			return err
+		}
+	}
+
+	d, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(eventsPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(d, '\n'))
+	return err
+}
+
+// handleEvents prints the recorded events as JSON lines, oldest first. With
+// --follow, it instead waits for and prints new events as they happen.
+func handleEvents(args []string) error {
+	flags := flag.NewFlagSet("events", flag.ExitOnError)
+	follow := flags.Bool("follow", false, "")
+	flags.Parse(args)
+
+	eventsPath, err := getEventsPath()
+	if err != nil {
+		return err
+	}
+
+	if !*follow {
+		f, err := os.Open(eventsPath)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(stdIO.Stdout, f)
+		return err
+	}
+
+	var offset int64
+	if info, err := os.Stat(eventsPath); err == nil {
+		offset = info.Size()
+	}
+
+	for range time.Tick(eventPollInterval) {
+		offset, err = copyEvents(eventsPath, offset)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyEvents prints the complete events written to the log after offset and
+// returns the offset to continue from. A log smaller than offset was rotated
+// and is read from the start.
+func copyEvents(eventsPath string, offset int64) (int64, error) {
+	f, err := os.Open(eventsPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return offset, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return offset, err
+	}
+	if info.Size() < offset {
+		offset = 0
+	}
+
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return offset, err
+	}
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if !bytes.HasSuffix(line, []byte("\n")) {
+			// A partially written event is printed once it is complete.
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+
+		_, err = stdIO.Stdout.Write(line)
+		if err != nil {
+			return offset, err
+		}
+		offset += int64(len(line))
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// exitCodeError makes tsm exit with a specific code without printing an
+// error message.
+type exitCodeError struct {
+	code int
+}
+
+func (e exitCodeError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+// handleExec ensures a project's session exists and runs a command in a new
+// window or pane of it. When waiting, tsm exits with the command's exit code.
+func handleExec(config Config, args []string) error {
+	flags := flag.NewFlagSet("exec", flag.ExitOnError)
+	pane := flags.Bool("pane", false, "")
+	wait := flags.Bool("wait", false, "")
+	keep := flags.Bool("keep", false, "")
+	flags.Parse(args)
+
+	// Parsing stops at the project, so the separator is still present.
+	commandArgs := flags.Args()
+	if len(commandArgs) > 1 && commandArgs[1] == "--" {
+		commandArgs = append(commandArgs[:1], commandArgs[2:]...)
+	}
+
+	if len(commandArgs) < 2 {
+		return errors.New("tsm: exec requires a project and a command")
+	}
+
+	targetDir, err := resolveProject(config, commandArgs[0])
+	if err != nil {
+		return err
+	}
+
+	id, err := ensureSession(config, targetDir)
+	if err != nil || id == "" {
+		return err
+	}
+
+	// A single argument is treated as a shell command line, as with ssh.
+	command := commandArgs[1]
+	if len(commandArgs) > 2 {
+		command = shellJoin(commandArgs[1:])
+	}
+
+	var codePath string
+	var waiter *exec.Cmd
+	if *wait {
+		f, err := os.CreateTemp("", "tsm-exec-")
+		if err != nil {
+			return err
+		}
+		f.Close()
+		defer os.Remove(f.Name())
+
+		codePath = f.Name()
+		channel := "tsm-exec-" + filepath.Base(codePath)
+		command += "; echo $? > " + shellQuote(codePath) + "; tmux wait-for -S " + channel
+
+		// The waiter is started before the command so the signal cannot
+		// be missed.
+		waiter = newCommand(IO{}, "tmux", "wait-for", channel)
+		err = waiter.Start()
+		if err != nil {
+			return err
+		}
+	}
+
+	if *keep {
+		command += `; exec "${SHELL:-/bin/sh}"`
+	}
+
+	create := []string{"tmux", "new-window", "-d", "-t", id + ":"}
+	if *pane {
+		create = []string{"tmux", "split-window", "-d", "-t", id + ":"}
+	}
+
+	err = runCommand(stdIO, append(create, "-c", targetDir, "sh", "-c", command)...)
+	if err != nil {
+		return err
+	}
+
+	if waiter == nil {
+		return nil
+	}
+
+	err = waiter.Wait()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.ReadFile(codePath)
+	if err != nil {
+		return err
+	}
+
+	code, err := strconv.Atoi(strings.TrimSpace(string(f)))
+	if err != nil {
+		return err
+	} else if code != 0 {
+		return exitCodeError{code: code}
+	}
+
+	return nil
+}
+
+// shellJoin quotes each argument for sh and joins them into a command line.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
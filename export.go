@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// shells are programs that are not recorded as a pane's command when a
+// session is exported since every pane starts one anyway.
+var shells = []string{"sh", "bash", "zsh", "fish", "nu", "dash", "ksh", "tcsh", "csh"}
+
+// handleExport prints a session's layout as a snapshot that can be shared and
+// recreated elsewhere with import. Pane directories are made relative to the
+// session's directory, the programs running in panes are recorded as their
+// commands, and only the names of the session's environment variables are
+// included.
+func handleExport(args []string) error {
+	id, err := sessionArg(args)
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := takeSnapshot(id)
+	if err != nil {
+		return err
+	}
+
+	commands, err := runCommandOutput("tmux", "list-panes", "-s", "-t", id,
+		"-F", tmuxFormat("#{window_index}", "#{pane_index}", "#{pane_current_command}"))
+	if err != nil {
+		return err
+	}
+
+	for _, line := range splitLines(commands) {
+		fields := strings.Split(line, fieldSep)
+		if len(fields) != 3 || isShell(fields[2]) {
+			continue
+		}
+
+		for i, window := range snapshot.Windows {
+			for j, pane := range window.Panes {
+				if strconv.Itoa(window.Index) == fields[0] && strconv.Itoa(pane.Index) == fields[1] {
+					snapshot.Windows[i].Panes[j].Command = fields[2]
+				}
+			}
+		}
+	}
+
+	for i, window := range snapshot.Windows {
+		for j, pane := range window.Panes {
+			rel, err := filepath.Rel(snapshot.Path, pane.Path)
+			if err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+				snapshot.Windows[i].Panes[j].Path = rel
+			}
+		}
+	}
+	snapshot.Path = ""
+
+	env, err := runCommandOutput("tmux", "show-environment", "-t", id)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range splitLines(env) {
+		// Variables removed from the session are prefixed with a dash.
+		name, _, _ := strings.Cut(line, "=")
+		if name != "" && !strings.HasPrefix(name, "-") {
+			snapshot.Env = append(snapshot.Env, name)
+		}
+	}
+
+	d, err := json.MarshalIndent(snapshot, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(stdIO.Stdout, "%s\n", d)
+	return err
+}
+
+func isShell(command string) bool {
+	return slices.Contains(shells, command) || command == filepath.Base(os.Getenv("SHELL"))
+}
+
+// handleImport recreates an exported session for a project directory, the
+// current directory by default. Variables named in the export are copied
+// from the environment tsm runs in.
+func handleImport(config Config, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	name := flags.String("name", "", "")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return errors.New("tsm: import requires a file")
+	}
+
+	f, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var snapshot Snapshot
+	err = json.Unmarshal(f, &snapshot)
+	if err != nil {
+		return fmt.Errorf("tsm: invalid session file %s: %w", flags.Arg(0), err)
+	}
+
+	targetDir := "."
+	if flags.NArg() > 1 {
+		targetDir = flags.Arg(1)
+	}
+
+	targetDir, err = filepath.Abs(targetDir)
+	if err != nil {
+		return err
+	}
+
+	if id, ok := findSessionForPath(targetDir); ok {
+		return fmt.Errorf("tsm: session %q already exists for %s", id, targetDir)
+	}
+
+	snapshot.Path = targetDir
+	if *name != "" {
+		snapshot.Name = *name
+	}
+	if snapshot.Name == "" {
+		snapshot.Name = filepath.Base(targetDir)
+	}
+	snapshot.Name = cleanID(config, snapshot.Name)
+	if sessionExists(snapshot.Name) {
+		snapshot.Name = nextFreeID(snapshot.Name)
+	}
+
+	for i, window := range snapshot.Windows {
+		for j, pane := range window.Panes {
+			if !filepath.IsAbs(pane.Path) {
+				snapshot.Windows[i].Panes[j].Path = filepath.Join(targetDir, pane.Path)
+			}
+		}
+	}
+
+	err = restoreSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdIO.Stdout, "Created session %q\n", snapshot.Name)
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"syscall"
+	"time"
+)
+
+// fetchProject starts a background `git fetch --prune` in a project
+// directory when auto_fetch is set and the repository was not
+// fetched within that interval. Fetches run detached so that switching is
+// never delayed by the network.
+func fetchProject(config Config, projectDir string) error {
+	if config.AutoFetch == "" {
+		return nil
+	}
+
+	interval, err := time.ParseDuration(config.AutoFetch)
+	if err != nil {
+		return fmt.Errorf("tsm: invalid auto_fetch: %w", err)
+	}
+
+	if _, err := os.Stat(path.Join(projectDir, ".git")); err != nil {
+		return nil
+	}
+
+	due := false
+	err = updateState(func(state *State) error {
+		if time.Since(state.Fetched[projectDir]) < interval {
+			return nil
+		}
+
+		due = true
+		if state.Fetched == nil {
+			state.Fetched = map[string]time.Time{}
+		}
+		state.Fetched[projectDir] = time.Now()
+
+		// Repositories that were not fetched recently are forgotten so the
+		// state does not grow with every project ever opened.
+		for dir, fetched := range state.Fetched {
+			if time.Since(fetched) >= interval {
+				delete(state.Fetched, dir)
+			}
+		}
+
+		return nil
+	})
+	if err != nil || !due {
+		return err
+	}
+
+	cmd := newCommand(IO{}, "git", "-C", projectDir, "fetch", "--prune", "--quiet")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	// Credential prompts would have nowhere to go.
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	return cmd.Process.Release()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lockFile takes an exclusive advisory lock guarding the file at p, blocking
+// until any other tsm process holding it releases it. The lock is held on a
+// separate lock file since p itself is replaced when written. Every
+// read-modify-write of a persisted file must hold the lock so that concurrent
+// invocations do not lose each other's updates.
+func lockFile(p string) (unlock func(), err error) {
+	err = os.MkdirAll(filepath.Dir(p), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(p+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	// Closing the file releases the lock.
+	return func() { f.Close() }, nil
+}
+
+// writeFileAtomic writes data to a temporary file next to p and renames it
+// into place, so that readers never see a partially written file. If p is a
+// symlink, e.g. to a config file kept in a dotfiles repository, its target is
+// replaced instead.
+func writeFileAtomic(p string, data []byte, perm os.FileMode) error {
+	if target, err := filepath.EvalSymlinks(p); err == nil {
+		p = target
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), p)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FileTarget makes files of a kind, such as VS Code workspaces or compose
+// files, entries of the picker next to the project directory holding them.
+// Selecting one opens a session of its own, rooted in the file's directory.
+type FileTarget struct {
+	// Pattern matches the names of files directly in project directories,
+	// e.g. "*.code-workspace".
+	Pattern string `json:"pattern"`
+	// Command runs in the first window of the file's session. The path of
+	// the file is in $TSM_FILE throughout the session.
+	Command string `json:"command,omitempty"`
+	// Template is applied to the session, after the command's window.
+	Template string `json:"template,omitempty"`
+}
+
+// walkFileTargets calls fn for every file in dir matching a file target.
+func walkFileTargets(config Config, dir string, fn func(string) error) error {
+	for _, ft := range config.FileTargets {
+		matches, err := filepath.Glob(path.Join(dir, ft.Pattern))
+		if err != nil {
+			return err
+		}
+
+		for _, match := range matches {
+			if info, err := os.Stat(match); err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+
+			err = fn(match)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// findFileTarget returns the file target matching a file, if p is one.
+func findFileTarget(config Config, p string) (FileTarget, bool) {
+	for _, ft := range config.FileTargets {
+		if ok, _ := filepath.Match(ft.Pattern, path.Base(p)); !ok {
+			continue
+		}
+
+		if info, err := os.Stat(p); err == nil && info.Mode().IsRegular() {
+			return ft, true
+		}
+	}
+
+	return FileTarget{}, false
+}
+
+// switchToFile switches to the session of a file target, creating it if
+// necessary.
+func switchToFile(config Config, file string, ft FileTarget) error {
+	if config.Mode == ModeWindows {
+		return errors.New("tsm: file targets require a session per project")
+	}
+
+	id, err := ensureFileSession(config, file, ft)
+	if err != nil || id == "" {
+		return err
+	}
+
+	return switchToSession(config, id)
+}
+
+// ensureFileSession returns the session of a file target, creating it if it
+// does not exist. The session is named after the file and its directory, and
+// remembers the file in pathOption so that it is found again. An empty ID is
+// returned if the user cancels while resolving a conflicting session.
+func ensureFileSession(config Config, file string, ft FileTarget) (string, error) {
+	unlock, err := lockProject(file)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	if id, ok := findSessionForPath(file); ok {
+		return id, nil
+	}
+
+	dir := path.Dir(file)
+	id := cleanID(config, path.Base(dir)+"-"+strings.TrimSuffix(path.Base(file), path.Ext(file)))
+
+	if sessionExists(id) {
+		id, err = resolveConflict(config, id, file)
+		if err != nil || id == "" {
+			return "", err
+		}
+	}
+
+	if !sessionExists(id) {
+		err = createFileSession(config, id, file, ft)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return id, nil
+}
+
+// createFileSession creates a detached session for a file target.
+func createFileSession(config Config, id, file string, ft FileTarget) error {
+	var t Template
+	if ft.Template != "" {
+		var err error
+		t, err = lookupTemplate(config, ft.Template)
+		if err != nil {
+			return err
+		}
+	}
+
+	t.Env = mergeEnv(t.Env, map[string]string{"TSM_FILE": file})
+	if ft.Command != "" {
+		t.Windows = append([]WindowTemplate{{Command: ft.Command}}, t.Windows...)
+	}
+
+	err := createTemplateSession(config, id, path.Dir(file), t)
+	if err != nil {
+		return err
+	}
+
+	err = runCommand(IO{}, "tmux", "set-option", "-t", id, pathOption, file)
+	if err != nil || ft.Template == "" {
+		return err
+	}
+
+	return runCommand(IO{}, "tmux", "set-option", "-t", id, templateOption, ft.Template)
+}
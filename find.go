@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultFindLines is the number of lines of each pane's history searched by
+// find --contents.
+const defaultFindLines = 1000
+
+// findMatch is a window found by find.
+type findMatch struct {
+	Session string
+	// Target is the window, or the pane whose contents matched.
+	Target string
+	Window string
+	// Line is the matching line of the pane, if the contents matched.
+	Line string
+}
+
+func (m findMatch) String() string {
+	if m.Line == "" {
+		return fmt.Sprintf("%s: %s", m.Session, m.Window)
+	}
+
+	return fmt.Sprintf("%s: %s: %s", m.Session, m.Window, m.Line)
+}
+
+// handleFind switches to the window whose name contains the text, ignoring
+// case. With --contents, the recent contents of every pane are searched too.
+// A picker lists the matches if there are several.
+func handleFind(config Config, args []string) error {
+	flags := flag.NewFlagSet("find", flag.ExitOnError)
+	contents := flags.Bool("contents", false, "")
+	lines := flags.Int("lines", defaultFindLines, "")
+	flags.Parse(args)
+
+	text := strings.ToLower(strings.Join(flags.Args(), " "))
+	if text == "" {
+		return errors.New("tsm: find requires the text to search for")
+	}
+
+	matches, err := findWindows(text)
+	if err != nil {
+		return err
+	}
+
+	if *contents {
+		paneMatches, err := findPaneContents(text, *lines)
+		if err != nil {
+			return err
+		}
+		matches = append(matches, paneMatches...)
+	}
+
+	var match findMatch
+	switch len(matches) {
+	case 0:
+		return fmt.Errorf("tsm: no window matches %q", strings.Join(flags.Args(), " "))
+	case 1:
+		match = matches[0]
+	default:
+		var input strings.Builder
+		for i, m := range matches {
+			fmt.Fprintf(&input, "%d\t%s\n", i, m)
+		}
+
+		out, err := runFzf(strings.NewReader(input.String()), "--delimiter", "\t", "--with-nth", "2..")
+		if err != nil {
+			return err
+		}
+
+		field, _, _ := strings.Cut(out, "\t")
+		i, err := strconv.Atoi(field)
+		if err != nil || i < 0 || i >= len(matches) {
+			return fmt.Errorf("tsm: unexpected fzf output %q", out)
+		}
+		match = matches[i]
+	}
+
+	err = runCommand(IO{}, "tmux", "select-window", "-t", match.Target)
+	if err != nil {
+		return err
+	}
+
+	// A pane target selects the pane that matched within its window.
+	if strings.HasPrefix(match.Target, "%") {
+		err = runCommand(IO{}, "tmux", "select-pane", "-t", match.Target)
+		if err != nil {
+			return err
+		}
+	}
+
+	return switchToSession(config, match.Session)
+}
+
+// findWindows returns the windows whose name contains text.
+func findWindows(text string) ([]findMatch, error) {
+	out, err := runCommandOutput("tmux", "list-windows", "-a",
+		"-F", tmuxFormat("#{session_name}", "#{window_id}", "#{window_name}"))
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []findMatch
+	for _, line := range splitLines(out) {
+		fields := strings.Split(line, fieldSep)
+		if len(fields) != 3 || !strings.Contains(strings.ToLower(fields[2]), text) {
+			continue
+		}
+
+		matches = append(matches, findMatch{Session: fields[0], Target: fields[1], Window: fields[2]})
+	}
+
+	return matches, nil
+}
+
+// findPaneContents returns the panes whose last n lines contain text, with
+// the most recent matching line of each.
+func findPaneContents(text string, n int) ([]findMatch, error) {
+	out, err := runCommandOutput("tmux", "list-panes", "-a",
+		"-F", tmuxFormat("#{session_name}", "#{pane_id}", "#{window_name}"))
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []findMatch
+	for _, line := range splitLines(out) {
+		// The pane tsm runs in shows the text being searched for.
+		fields := strings.Split(line, fieldSep)
+		if len(fields) != 3 || fields[1] == os.Getenv("TMUX_PANE") {
+			continue
+		}
+
+		// Panes that close meanwhile are skipped.
+		contents, err := runCommandOutput("tmux", "capture-pane", "-p", "-J", "-t", fields[1], "-S", strconv.Itoa(-n))
+		if err != nil {
+			continue
+		}
+
+		paneLines := splitLines(contents)
+		for i := len(paneLines) - 1; i >= 0; i-- {
+			if strings.Contains(strings.ToLower(paneLines[i]), text) {
+				matches = append(matches, findMatch{
+					Session: fields[0],
+					Target:  fields[1],
+					Window:  fields[2],
+					Line:    strings.TrimSpace(paneLines[i]),
+				})
+				break
+			}
+		}
+	}
+
+	return matches, nil
+}
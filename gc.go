@@ -0,0 +1,367 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// staleDir collects everything tsm records about a project directory that
+// no longer exists.
+type staleDir struct {
+	Path       string
+	Registered bool
+	Pinned     bool
+	Archived   bool
+	Fetched    bool
+	History    int
+	Running    int
+	Zellij     int
+	Snapshots  []string
+}
+
+func (s staleDir) String() string {
+	var parts []string
+	if s.Registered {
+		parts = append(parts, "registered")
+	}
+	if s.Pinned {
+		parts = append(parts, "pinned")
+	}
+	if s.Archived {
+		parts = append(parts, "archived")
+	}
+	if s.History > 0 {
+		parts = append(parts, plural(s.History, "switch", "switches"))
+	}
+	if s.Running > 0 {
+		parts = append(parts, plural(s.Running, "running record", "running records"))
+	}
+	if s.Zellij > 0 {
+		parts = append(parts, plural(s.Zellij, "zellij session record", "zellij session records"))
+	}
+	if len(s.Snapshots) > 0 {
+		parts = append(parts, plural(len(s.Snapshots), "snapshot", "snapshots"))
+	}
+	if s.Fetched {
+		parts = append(parts, "fetch time")
+	}
+
+	return fmt.Sprintf("%s (%s)", s.Path, strings.Join(parts, ", "))
+}
+
+func plural(n int, singular, pluralForm string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, singular)
+	}
+
+	return fmt.Sprintf("%d %s", n, pluralForm)
+}
+
+func handleState(configPath string, config Config, args []string) error {
+	if len(args) == 0 || args[0] != "gc" {
+		return errors.New("tsm: state requires a subcommand: gc")
+	}
+
+	return handleStateGC(configPath, config, args[1:])
+}
+
+// handleStateGC purges the records of project directories that no longer
+// exist. Each directory is reviewed in turn and can be removed, skipped, or
+// moved to where the project lives now, unless --yes or --dry-run is given.
+// Temporary directories of sessions that are gone, along with their records,
+// and trash entries past the undo grace period are always discarded.
+func handleStateGC(configPath string, config Config, args []string) error {
+	flags := flag.NewFlagSet("state gc", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "")
+	yes := flags.Bool("yes", false, "")
+	flags.Parse(args)
+
+	temp, err := cleanTempDirs(*dryRun)
+	if err != nil {
+		return err
+	}
+
+	if !*dryRun {
+		err = removeStaleDirs(configPath, config, temp)
+		if err != nil {
+			return err
+		}
+	}
+
+	stale, err := findStaleDirs(config)
+	if err != nil {
+		return err
+	}
+
+	var remove []string
+	for _, s := range stale {
+		fmt.Fprintln(stdIO.Stdout, s)
+
+		if *dryRun {
+			continue
+		} else if *yes {
+			remove = append(remove, s.Path)
+			continue
+		}
+
+		answer, err := prompt("[r]emove, [m]ove to a new path, [s]kip: ")
+		if err != nil {
+			return err
+		}
+
+		switch strings.ToLower(answer) {
+		case "r", "remove":
+			remove = append(remove, s.Path)
+		case "m", "move":
+			newDir, err := prompt("New path: ")
+			if err != nil {
+				return err
+			}
+
+			err = handleMv(configPath, config, []string{s.Path, expandHome(newDir)})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(stale) == 0 && len(temp) == 0 {
+		fmt.Fprintln(stdIO.Stdout, "No stale entries")
+	}
+
+	if *dryRun {
+		return nil
+	}
+
+	err = removeStaleDirs(configPath, config, remove)
+	if err != nil {
+		return err
+	} else if len(remove) > 0 {
+		fmt.Fprintf(stdIO.Stdout, "Removed the records of %s\n", plural(len(remove), "directory", "directories"))
+	}
+
+	return purgeTrash(config)
+}
+
+// findStaleDirs returns the directories referenced by the registry, the
+// state file, and saved snapshots that no longer exist, sorted by path.
+// Remote targets are never considered stale.
+func findStaleDirs(config Config) ([]staleDir, error) {
+	state, err := loadState()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshotsPath, err := getSnapshotsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots, err := readSnapshots(snapshotsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	stale := map[string]*staleDir{}
+	exists := map[string]bool{}
+	lookup := func(dir string) *staleDir {
+		if !strings.HasPrefix(dir, "/") {
+			return nil
+		}
+
+		dir = path.Clean(dir)
+		if _, ok := exists[dir]; !ok {
+			_, err := os.Stat(dir)
+			exists[dir] = !errors.Is(err, os.ErrNotExist)
+		}
+		if exists[dir] {
+			return nil
+		}
+
+		if stale[dir] == nil {
+			stale[dir] = &staleDir{Path: dir}
+		}
+		return stale[dir]
+	}
+
+	for _, p := range config.Projects {
+		if s := lookup(expandHome(p.Path)); s != nil {
+			s.Registered = true
+		}
+	}
+	for _, pin := range state.Pins {
+		if s := lookup(pin); s != nil {
+			s.Pinned = true
+		}
+	}
+	for _, p := range state.Archived {
+		if s := lookup(p); s != nil {
+			s.Archived = true
+		}
+	}
+	for dir := range state.Fetched {
+		if s := lookup(dir); s != nil {
+			s.Fetched = true
+		}
+	}
+	for _, entry := range state.History {
+		if s := lookup(entry.Path); s != nil {
+			s.History++
+		}
+	}
+	for _, sessions := range state.Running {
+		for _, session := range sessions {
+			if s := lookup(session.Path); s != nil {
+				s.Running++
+			}
+		}
+	}
+	for _, dir := range state.ZellijSessions {
+		if s := lookup(dir); s != nil {
+			s.Zellij++
+		}
+	}
+	for id, snapshot := range snapshots {
+		if s := lookup(snapshot.Path); s != nil {
+			s.Snapshots = append(s.Snapshots, id)
+		}
+	}
+
+	dirs := make([]staleDir, 0, len(stale))
+	for _, s := range stale {
+		sort.Strings(s.Snapshots)
+		dirs = append(dirs, *s)
+	}
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].Path < dirs[j].Path })
+
+	return dirs, nil
+}
+
+// removeStaleDirs drops every record of the given directories.
+func removeStaleDirs(configPath string, config Config, dirs []string) error {
+	if len(dirs) == 0 {
+		return nil
+	}
+
+	isStale := func(dir string) bool {
+		return strings.HasPrefix(dir, "/") && slices.Contains(dirs, path.Clean(dir))
+	}
+
+	if slices.ContainsFunc(config.Projects, func(p ProjectConfig) bool { return isStale(expandHome(p.Path)) }) {
+		err := updateConfigFile(configPath, func(config *Config) error {
+			config.Projects = slices.DeleteFunc(config.Projects, func(p ProjectConfig) bool {
+				return isStale(expandHome(p.Path))
+			})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err := updateState(func(state *State) error {
+		state.Pins = slices.DeleteFunc(state.Pins, isStale)
+		state.Archived = slices.DeleteFunc(state.Archived, isStale)
+		for day, sessions := range state.Running {
+			state.Running[day] = slices.DeleteFunc(sessions, func(session Session) bool {
+				return isStale(session.Path)
+			})
+		}
+		for dir := range state.Fetched {
+			if isStale(dir) {
+				delete(state.Fetched, dir)
+			}
+		}
+		for id, dir := range state.ZellijSessions {
+			if isStale(dir) {
+				delete(state.ZellijSessions, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	store, err := openStateStore()
+	if err != nil {
+		return err
+	}
+	err = store.RewriteHistory(func(entry HistoryEntry) (HistoryEntry, bool) {
+		return entry, !isStale(entry.Path)
+	})
+	if err != nil {
+		return err
+	}
+
+	snapshotsPath, err := getSnapshotsPath()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(snapshotsPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	snapshots, err := readSnapshots(snapshotsPath)
+	if err != nil {
+		return err
+	}
+
+	for id, snapshot := range snapshots {
+		if isStale(snapshot.Path) {
+			delete(snapshots, id)
+		}
+	}
+
+	return writeSnapshots(snapshotsPath, snapshots)
+}
+
+// purgeTrash discards trash entries that can no longer be undone.
+func purgeTrash(config Config) error {
+	grace, err := undoGrace(config)
+	if err != nil {
+		return err
+	}
+
+	trashPath, err := getTrashPath()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(trashPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	trash, err := readTrash(trashPath)
+	if err != nil {
+		return err
+	}
+
+	kept := slices.DeleteFunc(slices.Clone(trash), func(entry TrashEntry) bool {
+		return time.Since(entry.KilledAt) >= grace
+	})
+	if len(kept) == len(trash) {
+		return nil
+	}
+
+	err = writeTrash(trashPath, kept)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdIO.Stdout, "Discarded %s\n", plural(len(trash)-len(kept), "expired trash entry", "expired trash entries"))
+
+	return nil
+}
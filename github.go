@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// pullRequestPrefix starts the picker entries of pull requests, e.g.
+// "gh:org/repo#42".
+const pullRequestPrefix = "gh:"
+
+type GitHubConfig struct {
+	// Dir is where pull requests listed by the gh source are cloned, one
+	// directory per pull request. It defaults to the first base dir.
+	Dir string `json:"dir,omitempty"`
+}
+
+// PullRequest is an open pull request listed by the gh source.
+type PullRequest struct {
+	// Repo is the repository in owner/name form.
+	Repo   string
+	Number int
+	Title  string
+}
+
+func (pr PullRequest) String() string {
+	return fmt.Sprintf("%s%s#%d", pullRequestPrefix, pr.Repo, pr.Number)
+}
+
+// parsePullRequest parses a picker entry of the gh source.
+func parsePullRequest(entry string) (PullRequest, bool) {
+	rest, ok := strings.CutPrefix(entry, pullRequestPrefix)
+	if !ok {
+		return PullRequest{}, false
+	}
+
+	repo, number, ok := strings.Cut(rest, "#")
+	n, err := strconv.Atoi(number)
+	if !ok || err != nil || strings.Count(repo, "/") != 1 {
+		return PullRequest{}, false
+	}
+
+	return PullRequest{Repo: repo, Number: n}, true
+}
+
+// walkPullRequests calls fn for every open pull request that requests your
+// review or is assigned to you, if the gh source is enabled. The title is
+// displayed after the entry. Pull requests are skipped if gh fails, e.g.
+// while offline, so that the rest of the picker still works.
+func walkPullRequests(config Config, fn func(string) error) error {
+	if !slices.Contains(config.Sources, SourceGH) {
+		return nil
+	}
+
+	ctx, cancel := timeoutContext(config, TimeoutGH)
+	defer cancel()
+
+	prs, err := listPullRequests(ctx)
+	if err != nil {
+		return nil
+	}
+
+	for _, pr := range prs {
+		err = fn(pr.String() + "\t" + pr.Title)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listPullRequests returns the open pull requests that request your review
+// or are assigned to you, ordered by repository and number.
+func listPullRequests(ctx context.Context) ([]PullRequest, error) {
+	var prs []PullRequest
+	for _, filter := range []string{"--review-requested=@me", "--assignee=@me"} {
+		out, err := runCommandOutputContext(ctx, "gh", "search", "prs", "--state=open", filter,
+			"--json", "repository,number,title", "--limit", "100")
+		if err != nil {
+			return nil, err
+		}
+
+		var results []struct {
+			Repository struct {
+				NameWithOwner string `json:"nameWithOwner"`
+			} `json:"repository"`
+			Number int    `json:"number"`
+			Title  string `json:"title"`
+		}
+		err = json.Unmarshal([]byte(out), &results)
+		if err != nil {
+			return nil, fmt.Errorf("tsm: parsing gh output: %w", err)
+		}
+
+		for _, r := range results {
+			pr := PullRequest{Repo: r.Repository.NameWithOwner, Number: r.Number, Title: r.Title}
+			if !slices.ContainsFunc(prs, func(p PullRequest) bool { return p.String() == pr.String() }) {
+				prs = append(prs, pr)
+			}
+		}
+	}
+
+	slices.SortFunc(prs, func(a, b PullRequest) int {
+		if a.Repo != b.Repo {
+			return strings.Compare(a.Repo, b.Repo)
+		}
+		return a.Number - b.Number
+	})
+
+	return prs, nil
+}
+
+// checkoutPullRequest clones the repository of a pull request into a
+// directory of its own, if it was not cloned before, and checks out the pull
+// request's branch. The directory is returned.
+func checkoutPullRequest(config Config, pr PullRequest) (string, error) {
+	cloneDir := config.GitHub.Dir
+	if cloneDir == "" && len(config.BaseDirs) > 0 {
+		cloneDir = config.BaseDirs[0]
+	}
+	if cloneDir == "" {
+		return "", errors.New("tsm: set github.dir or base_dirs to check out pull requests")
+	}
+
+	dir := path.Join(expandHome(cloneDir), fmt.Sprintf("%s-pr-%d", path.Base(pr.Repo), pr.Number))
+
+	inOut := IO{Stdout: stdIO.Stderr, Stderr: stdIO.Stderr}
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		err = runCommand(inOut, "gh", "repo", "clone", pr.Repo, dir)
+		if err != nil {
+			return "", fmt.Errorf("tsm: cloning %s: %w", pr.Repo, err)
+		}
+	}
+
+	// Checking out again brings the branch up to date with the pull request.
+	cmd := newCommand(inOut, "gh", "pr", "checkout", strconv.Itoa(pr.Number))
+	cmd.Dir = dir
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("tsm: checking out %s: %w", pr, err)
+	}
+
+	return dir, nil
+}
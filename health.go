@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Git indicators shown next to repositories in the picker.
+const (
+	// GitBranch shows the checked out branch.
+	GitBranch = "branch"
+	// GitDirty marks repositories with uncommitted changes with "*".
+	GitDirty = "dirty"
+	// GitAheadBehind shows the commits ahead of and behind the upstream
+	// branch, e.g. "⇡2⇣1".
+	GitAheadBehind = "ahead_behind"
+	// GitStash shows the number of stashes, e.g. "$3".
+	GitStash = "stash"
+)
+
+// gitIndicators describes the state of the repository at dir using the
+// enabled indicators. Except for the branch, each indicator costs a git
+// invocation, so only the enabled ones are computed. An empty string is
+// returned if dir is not a repository or nothing needs attention. Indicators
+// whose git command is still running once ctx is done are left out.
+func gitIndicators(ctx context.Context, enabled []string, dir string) string {
+	branch := gitBranch(dir)
+	if branch == "" {
+		return ""
+	}
+
+	var b strings.Builder
+	if slices.Contains(enabled, GitBranch) {
+		b.WriteString(branch)
+	}
+
+	if slices.Contains(enabled, GitDirty) {
+		if out, err := gitOutput(ctx, dir, "status", "--porcelain", "--untracked-files=normal"); err == nil && out != "" {
+			b.WriteString("*")
+		}
+	}
+
+	if slices.Contains(enabled, GitAheadBehind) {
+		out, err := gitOutput(ctx, dir, "rev-list", "--left-right", "--count", "HEAD...@{upstream}")
+		var ahead, behind int
+		if _, scanErr := fmt.Sscan(out, &ahead, &behind); err == nil && scanErr == nil {
+			if ahead > 0 {
+				fmt.Fprintf(&b, "⇡%d", ahead)
+			}
+			if behind > 0 {
+				fmt.Fprintf(&b, "⇣%d", behind)
+			}
+		}
+	}
+
+	if slices.Contains(enabled, GitStash) {
+		// Listing the stash reflog fails when there are no stashes.
+		out, err := gitOutput(ctx, dir, "rev-list", "--walk-reflogs", "--count", "refs/stash")
+		if err == nil && out != "0" {
+			fmt.Fprintf(&b, " $%s", out)
+		}
+	}
+
+	return strings.TrimSpace(b.String())
+}
+
+func gitOutput(ctx context.Context, dir string, args ...string) (string, error) {
+	out, err := runCommandOutputContext(ctx, append([]string{"git", "-C", path.Clean(dir)}, args...)...)
+	return strings.TrimSpace(out), err
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+)
+
+// maxHistory is the number of switches kept in the state file.
+const maxHistory = 10000
+
+// HistoryEntry records a switch to a session.
+type HistoryEntry struct {
+	Session string    `json:"session"`
+	Path    string    `json:"path"`
+	Time    time.Time `json:"time"`
+}
+
+// updateState applies update to the stored state.
+func updateState(update func(*State) error) error {
+	store, err := openStateStore()
+	if err != nil {
+		return err
+	}
+
+	return store.Update(update)
+}
+
+// recordSwitch records a switch to the session, or window, of a project
+// directory.
+func recordSwitch(id, sessionDir string) error {
+	recordEvent(EventSwitched, id, sessionDir)
+
+	return updateState(func(state *State) error {
+		state.History = append(state.History, HistoryEntry{
+			Session: id,
+			Path:    sessionDir,
+			Time:    time.Now(),
+		})
+		state.HistoryCursor = 0
+		return nil
+	})
+}
+
+func handleHistory(args []string) error {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	since := flags.String("since", "", "")
+	project := flags.String("project", "", "")
+	asJSON := flags.Bool("json", false, "")
+	flags.Parse(args)
+
+	var after time.Time
+	if *since != "" {
+		var err error
+		after, err = parseSince(*since)
+		if err != nil {
+			return err
+		}
+	}
+
+	store, err := openStateStore()
+	if err != nil {
+		return err
+	}
+
+	history, err := store.History(after)
+	if err != nil {
+		return err
+	}
+
+	entries := []HistoryEntry{}
+	for _, entry := range history {
+		if entry.Time.Before(after) {
+			continue
+		}
+
+		if *project != "" && entry.Session != *project && entry.Path != *project {
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if *asJSON {
+		return json.NewEncoder(stdIO.Stdout).Encode(entries)
+	}
+
+	for _, entry := range entries {
+		fmt.Fprintf(stdIO.Stdout, "%s  %s  %s\n", entry.Time.Local().Format("2006-01-02 15:04"), entry.Session, entry.Path)
+	}
+
+	return nil
+}
+
+// parseSince accepts either a duration before now, e.g. "24h", or a date in
+// YYYY-MM-DD form.
+func parseSince(since string) (time.Time, error) {
+	if d, err := time.ParseDuration(since); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	t, err := time.ParseInLocation(time.DateOnly, since, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("tsm: invalid --since %q: expected a duration or YYYY-MM-DD", since)
+	}
+
+	return t, nil
+}
+
+// handleLast switches to the most recently used session other than the
+// current one.
+func handleLast(config Config) error {
+	state, err := loadState()
+	if err != nil {
+		return err
+	}
+
+	current, _ := currentSession()
+	for i := len(state.History) - 1; i >= 0; i-- {
+		id := state.History[i].Session
+		if id != current && sessionExists(id) {
+			return switchToSession(config, id)
+		}
+	}
+
+	return errors.New("tsm: no previous session")
+}
+
+// handleHistoryStep switches to the session before (step -1, prev) or after
+// (step 1, next) the one the history cursor is at, skipping repeats of it and
+// sessions that no longer exist. Moving through the history is not recorded
+// as a switch, so that prev can be repeated to go further back.
+func handleHistoryStep(config Config, step int) error {
+	// Sessions are looked up before taking the state lock, which would hold
+	// off other tsm processes while tmux runs.
+	state, err := loadState()
+	if err != nil {
+		return err
+	}
+
+	n := len(state.History)
+	at := n - 1 - state.HistoryCursor
+	if at < 0 || at >= n {
+		at = n - 1
+	}
+
+	var id string
+	cursor := state.HistoryCursor
+	for i := at + step; at >= 0 && i >= 0 && i < n; i += step {
+		s := state.History[i].Session
+		if s != state.History[at].Session && sessionExists(s) {
+			id = s
+			cursor = n - 1 - i
+			break
+		}
+	}
+
+	if id != "" {
+		err = updateState(func(s *State) error {
+			// A switch recorded in the meantime starts over from it.
+			if s.HistoryCursor != state.HistoryCursor {
+				return errStateUnchanged
+			}
+
+			s.HistoryCursor = cursor
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if id == "" {
+		if step < 0 {
+			return errors.New("tsm: no earlier session in the history")
+		}
+		return errors.New("tsm: no later session in the history; use prev first")
+	}
+
+	return mux.Attach(config, id)
+}
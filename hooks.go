@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// defaultOnKillTimeout is how long each on_kill command may run unless
+// on_kill_timeout is set.
+const defaultOnKillTimeout = 30 * time.Second
+
+func getHooksLogPath() (string, error) {
+	return getStatePath("hooks.log")
+}
+
+// runKillHooks runs the on_kill commands of a session's template, followed by
+// those of the registered project, in the project directory. Their output is
+// appended to the hooks log. Failing commands are reported but never prevent
+// the session from being killed.
+func runKillHooks(config Config, id, dir string) {
+	// Sessions of file targets run their hooks in the file's directory.
+	if _, ok := findFileTarget(config, dir); ok {
+		dir = path.Dir(dir)
+	}
+
+	name, err := runCommandOutput("tmux", "show-option", "-qv", "-t", id, templateOption)
+	name = strings.TrimSpace(name)
+	if err != nil || name == "" {
+		name = projectTemplate(config, dir)
+	}
+
+	var t Template
+	if name != "" {
+		// A template removed from the config has nothing to tear down.
+		t, _ = lookupTemplate(config, name)
+	}
+
+	// The template's commands are copied so that appending cannot write
+	// into the config.
+	commands := slices.Clone(t.OnKill)
+	if p, ok := findProject(config, dir); ok {
+		commands = append(commands, p.OnKill...)
+	}
+	if len(commands) == 0 {
+		return
+	}
+
+	timeout := defaultOnKillTimeout
+	if t.OnKillTimeout != "" {
+		if d, err := time.ParseDuration(t.OnKillTimeout); err == nil {
+			timeout = d
+		}
+	}
+
+	env := append(os.Environ(), "TSM_SESSION="+id, "TSM_PATH="+dir)
+	keys := make([]string, 0, len(t.Env))
+	for k := range t.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = append(env, k+"="+t.Env[k])
+	}
+
+	logPath, err := getHooksLogPath()
+	if err != nil {
+		return
+	}
+
+	for _, command := range commands {
+		out, err := runKillHook(dir, env, timeout, command)
+		if err != nil {
+			fmt.Fprintf(stdIO.Stderr, "tsm: on_kill command %q of session %q failed: %v (see %s)\n", command, id, err, logPath)
+		}
+
+		// The log is best effort and must not fail the kill.
+		_ = appendHookLog(logPath, id, command, out, err)
+	}
+}
+
+// runKillHook runs a command with sh in dir and returns its combined output.
+// The command and any processes it started are killed after the timeout.
+func runKillHook(dir string, env []string, timeout time.Duration, command string) ([]byte, error) {
+	var out bytes.Buffer
+	cmd := newCommand(IO{Stdout: &out, Stderr: &out}, "sh", "-c", command)
+	cmd.Dir = dir
+	cmd.Env = env
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	// Children of a killed command may keep its output open.
+	cmd.WaitDelay = 100 * time.Millisecond
+
+	err := cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.AfterFunc(timeout, func() { syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) })
+	defer timer.Stop()
+
+	err = cmd.Wait()
+	if err != nil && !timer.Stop() {
+		return out.Bytes(), fmt.Errorf("timed out after %s", timeout)
+	}
+
+	return out.Bytes(), err
+}
+
+// appendHookLog records the outcome and output of a hook command. The log is
+// rotated like the event log.
+func appendHookLog(logPath, id, command string, out []byte, runErr error) error {
+	err := os.MkdirAll(path.Dir(logPath), 0700)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(logPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if info, err := os.Stat(logPath); err == nil && info.Size() >= maxEventsSize {
+		err = os.Rename(logPath, logPath+".1")
+		if err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	status := "ok"
+	if runErr != nil {
+		status = runErr.Error()
+	}
+
+	fmt.Fprintf(f, "%s %s on_kill %q: %s\n", time.Now().Format(time.RFC3339), id, command, status)
+	_, err = f.Write(out)
+	if err == nil && len(out) > 0 && out[len(out)-1] != '\n' {
+		_, err = f.Write([]byte("\n"))
+	}
+
+	return err
+}
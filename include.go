@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// loadConfigLayers reads a config file and the files it includes, returning
+// the merged JSON document. Included files are layered on top of the file
+// that includes them in the order they are listed, and may include further
+// files themselves. Unless mark is nil, it is called with each file's
+// settings before they are merged.
+func loadConfigLayers(configPath string, seen []string, mark func(map[string]any, string)) (map[string]any, error) {
+	for _, p := range seen {
+		if p == configPath {
+			return nil, fmt.Errorf("tsm: config include cycle at %s", configPath)
+		}
+	}
+	seen = append(seen, configPath)
+
+	f, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var layer map[string]any
+	err = json.Unmarshal(f, &layer)
+	if err != nil {
+		return nil, fmt.Errorf("tsm: %s: %w", configPath, err)
+	}
+
+	includes, _ := layer["include"].([]any)
+	delete(layer, "include")
+	if mark != nil {
+		mark(layer, configPath)
+	}
+
+	for _, include := range includes {
+		includePath, ok := include.(string)
+		if !ok {
+			return nil, fmt.Errorf("tsm: %s: include entries must be strings", configPath)
+		}
+
+		includePath = expandHome(includePath)
+		if !path.IsAbs(includePath) {
+			includePath = path.Join(path.Dir(configPath), includePath)
+		}
+
+		included, err := loadConfigLayers(includePath, seen, mark)
+		if err != nil {
+			return nil, err
+		}
+
+		mergeConfig(layer, included)
+	}
+
+	return layer, nil
+}
+
+// mergeConfig layers src onto dst. Lists are appended and objects are
+// merged the same way entry by entry, however deeply they are nested, e.g.
+// the windows of a template defined in both are appended. Any other value in
+// src overrides the one in dst.
+func mergeConfig(dst, src map[string]any) {
+	for key, srcValue := range src {
+		switch srcValue := srcValue.(type) {
+		case []any:
+			if dstValue, ok := dst[key].([]any); ok {
+				dst[key] = append(dstValue, srcValue...)
+				continue
+			}
+		case map[string]any:
+			if dstValue, ok := dst[key].(map[string]any); ok {
+				mergeConfig(dstValue, srcValue)
+				continue
+			}
+		}
+
+		dst[key] = srcValue
+	}
+}
+
+// hostsKey holds settings that override the config on specific machines,
+// keyed by hostname.
+const hostsKey = "hosts"
+
+// applyHostOverrides layers the overrides for the current host onto config
+// and removes all host overrides. Unlike included files, overrides replace
+// lists, while objects are still merged.
+func applyHostOverrides(config map[string]any) error {
+	hosts, _ := config[hostsKey].(map[string]any)
+	delete(config, hostsKey)
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	if overrides, ok := hosts[hostname].(map[string]any); ok {
+		overrideConfig(config, overrides)
+	}
+
+	return nil
+}
+
+// overrideConfig layers src onto dst like mergeConfig, except that lists
+// replace those in dst.
+func overrideConfig(dst, src map[string]any) {
+	for key, srcValue := range src {
+		srcObject, ok := srcValue.(map[string]any)
+		if dstObject, isObject := dst[key].(map[string]any); ok && isObject {
+			overrideConfig(dstObject, srcObject)
+			continue
+		}
+
+		dst[key] = srcValue
+	}
+}
+
+// expandHome replaces a leading ~ with the user's home directory.
+func expandHome(p string) string {
+	if p != "~" && !strings.HasPrefix(p, "~/") {
+		return p
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+
+	return path.Join(home, p[1:])
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// commonProjectDirs are directories below the home directory that init
+// offers as base dirs if they exist.
+var commonProjectDirs = []string{"code", "src", "projects", "dev", "work", "repos", "git", "Developer", "workspace"}
+
+// handleInit adds base dirs to the config. Directories given as arguments
+// are added as they are. Otherwise each common project directory found in
+// the home directory is offered.
+func handleInit(configPath string, config Config, args []string) error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+
+	configured := func(dir string) bool {
+		return slices.ContainsFunc(config.BaseDirs, func(d string) bool {
+			return path.Clean(expandHome(d)) == dir
+		})
+	}
+
+	var dirs []string
+	for _, arg := range args {
+		dir, err := filepath.Abs(expandHome(arg))
+		if err != nil {
+			return err
+		}
+
+		info, err := os.Stat(dir)
+		if err != nil {
+			return err
+		} else if !info.IsDir() {
+			return fmt.Errorf("tsm: %s is not a directory", dir)
+		}
+
+		if !configured(dir) && !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	if len(args) == 0 {
+		var candidates []string
+		for _, name := range commonProjectDirs {
+			dir := path.Join(home, name)
+			if info, err := os.Stat(dir); err == nil && info.IsDir() && !configured(dir) {
+				candidates = append(candidates, dir)
+			}
+		}
+
+		if len(candidates) == 0 && len(config.BaseDirs) == 0 {
+			return errors.New("tsm: no common project directories found; pass the directories containing your projects, e.g. tsm init ~/code")
+		}
+
+		for _, dir := range candidates {
+			ok, err := confirm("Add %s to base_dirs?", dir)
+			if err != nil {
+				return err
+			} else if ok {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+
+	if len(dirs) == 0 {
+		fmt.Fprintf(stdIO.Stdout, "No base dirs added to %s\n", configPath)
+		return nil
+	}
+
+	// Paths in the home directory are written with ~ so that the config can
+	// be shared between machines.
+	for i, dir := range dirs {
+		if rel, ok := strings.CutPrefix(dir, home+"/"); ok {
+			dirs[i] = "~/" + rel
+		}
+	}
+
+	err = updateConfigFile(configPath, func(config *Config) error {
+		config.BaseDirs = append(config.BaseDirs, dirs...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdIO.Stdout, "Added %s to base_dirs in %s\n", strings.Join(dirs, ", "), configPath)
+
+	return nil
+}
+
+// emptyPickerError explains why the picker has nothing to list and how to
+// fix it. Inside tmux, where the picker may run in a popup that closes along
+// with tsm, the explanation is also shown in the status line.
+func emptyPickerError(configPath string, config Config) error {
+	var reason string
+	switch {
+	case config.stdinCandidates:
+		reason = "no paths were read from stdin"
+	case len(config.BaseDirs) == 0 && len(config.Projects) == 0:
+		reason = fmt.Sprintf("no base_dirs are configured in %s; run tsm init to add them", configPath)
+	default:
+		reason = fmt.Sprintf("the base_dirs in %s are empty or all of their directories are ignored or hidden; run tsm init to add more", configPath)
+	}
+
+	if insideTmux() {
+		// The error is still printed if the message cannot be shown.
+		_ = runCommand(IO{}, "tmux", "display-message", "tsm: no projects found: "+reason)
+	}
+
+	return fmt.Errorf("tsm: no projects found: %s", reason)
+}
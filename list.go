@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// minPathWidth is the narrowest the path column of list is shown at.
+// Anything narrower is too truncated to be useful, so the column is dropped.
+const minPathWidth = 12
+
+// Orders of the list command.
+const (
+	ListSortName = "name"
+	// ListSortIdle lists the longest idle sessions first, followed by
+	// projects without a session.
+	ListSortIdle = "idle"
+)
+
+// handleList prints the discovered projects in columns of name, status, and
+// path. The status of a project with a running session is how long the
+// session has been idle. When printing to a terminal, the columns are fit to
+// its width: paths are shortened in the middle and dropped entirely on very
+// narrow panes so that names and statuses always stay visible.
+func handleList(config Config, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "")
+	sortBy := flags.String("sort", "", "")
+	minIdle := flags.Duration("idle", 0, "")
+	flags.Parse(args)
+
+	projects, err := listProjects(config)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if *minIdle > 0 {
+		projects = slices.DeleteFunc(projects, func(p Project) bool {
+			return p.Activity == nil || now.Sub(*p.Activity) < *minIdle
+		})
+	}
+
+	switch *sortBy {
+	case "":
+	case ListSortName:
+		sort.SliceStable(projects, func(i, j int) bool {
+			return projects[i].Name < projects[j].Name
+		})
+	case ListSortIdle:
+		sort.SliceStable(projects, func(i, j int) bool {
+			a, b := projects[i].Activity, projects[j].Activity
+			return a != nil && (b == nil || a.Before(*b))
+		})
+	default:
+		return fmt.Errorf("tsm: invalid sort order %q", *sortBy)
+	}
+
+	if *asJSON {
+		return json.NewEncoder(stdIO.Stdout).Encode(projects)
+	}
+
+	nameWidth := 0
+	for _, p := range projects {
+		nameWidth = max(nameWidth, utf8.RuneCountInString(p.Name))
+	}
+
+	const statusWidth = len("idle 999d")
+	width := terminalWidth()
+	if width > 0 {
+		// Names give way to the status column, which always fits.
+		nameWidth = max(min(nameWidth, width-statusWidth-2), 1)
+	}
+	pathWidth := width - nameWidth - statusWidth - 4
+
+	for _, p := range projects {
+		status := ""
+		if p.Activity != nil {
+			status = idleStatus(now.Sub(*p.Activity))
+		}
+
+		line := fmt.Sprintf("%-*s  %-*s", nameWidth, truncateEnd(p.Name, nameWidth), statusWidth, status)
+		if width == 0 {
+			line += "  " + p.Path
+		} else if pathWidth >= minPathWidth {
+			line += "  " + truncateMiddle(p.Path, pathWidth)
+		}
+
+		fmt.Fprintln(stdIO.Stdout, strings.TrimRight(line, " "))
+	}
+
+	return nil
+}
+
+// idleStatus describes how long a session has been idle, e.g. "idle 3h".
+func idleStatus(idle time.Duration) string {
+	switch {
+	case idle < time.Minute:
+		return "active"
+	case idle < time.Hour:
+		return fmt.Sprintf("idle %dm", int(idle.Minutes()))
+	case idle < 48*time.Hour:
+		return fmt.Sprintf("idle %dh", int(idle.Hours()))
+	default:
+		return fmt.Sprintf("idle %dd", int(idle.Hours()/24))
+	}
+}
+
+// terminalWidth returns the number of columns available to the output, or 0
+// if it is not a terminal and lines should not be truncated. $COLUMNS takes
+// precedence so that the width can be forced, e.g. in popups.
+func terminalWidth() int {
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		return columns
+	}
+
+	f, ok := stdIO.Stdout.(*os.File)
+	if !ok {
+		return 0
+	}
+
+	var size struct{ rows, cols, x, y uint16 }
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&size)))
+	if errno != 0 {
+		return 0
+	}
+
+	return int(size.cols)
+}
+
+// truncateEnd shortens s to width runes, marking the cut with an ellipsis.
+func truncateEnd(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+
+	return string(runes[:width-1]) + "…"
+}
+
+// truncateMiddle shortens s to width runes by replacing its middle with an
+// ellipsis, keeping both the start and the more specific end of a path.
+func truncateMiddle(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+
+	head := (width - 1) / 2
+	tail := width - 1 - head
+	return string(runes[:head]) + "…" + string(runes[len(runes)-tail:])
+}
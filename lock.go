@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// lockedOption is the tmux user option marking a session as locked. Locked
+// sessions are only attached read-only and destructive commands ask for
+// confirmation before targeting them.
+const lockedOption = "@tsm_locked"
+
+func handleLock(args []string, locked bool) error {
+	id, err := sessionArg(args)
+	if err != nil {
+		return err
+	}
+
+	if !sessionExists(id) {
+		return fmt.Errorf("tsm: session %q does not exist", id)
+	}
+
+	if locked {
+		return runCommand(IO{}, "tmux", "set-option", "-t", id, lockedOption, "1")
+	}
+
+	return runCommand(IO{}, "tmux", "set-option", "-u", "-t", id, lockedOption)
+}
+
+func sessionLocked(id string) bool {
+	out, err := runCommandOutput("tmux", "show-options", "-qv", "-t", id, lockedOption)
+	return err == nil && strings.TrimSpace(out) == "1"
+}
+
+// confirmUnlocked asks the user to confirm an action that would destroy a
+// locked session. Unlocked sessions are always confirmed.
+func confirmUnlocked(id, action string) (bool, error) {
+	if !sessionLocked(id) {
+		return true, nil
+	}
+
+	return confirm("Session %q is locked. %s anyway?", id, action)
+}
+
+// sessionArg returns the session named by the first argument, or the current
+// session if no arguments are given.
+func sessionArg(args []string) (string, error) {
+	if len(args) > 0 {
+		return args[0], nil
+	}
+
+	return currentSession()
+}
+
+func currentSession() (string, error) {
+	if !insideTmux() {
+		return "", errors.New("tsm: not inside tmux; provide a session name")
+	}
+
+	out, err := runCommandOutput("tmux", "display-message", "-p", "#{session_name}")
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(out), nil
+}
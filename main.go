@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -10,8 +12,14 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 const AppUsage = `tsm - The Tmux Session Manager
@@ -25,23 +33,106 @@ USAGE:
     tsm [OPTIONS] [COMMAND]
 
 COMMANDS:
-    0                     Switch to the zero session.
+    ANCHOR                Switch to an anchor session, e.g. 0.
+    QUERY                 Switch to the project named or prefixed QUERY.
+    anchors               List anchor sessions.
+    switch PATH|NAME      Switch to the session for a project directory.
+    which [SESSION]       Print the project directory of a session.
+    why [PATH]            Explain how a project is found, named, and set up.
+    sub [QUERY]           Open a component of the current repository.
+    list [OPTIONS]        List projects with their session status.
+    ui                    Browse and manage projects and sessions.
+    edit [PROJECT]        Switch to a project's editor window.
+    find [OPTIONS] TEXT   Switch to the window whose name or output matches.
+    tmp [NAME]            Switch to a new session in a scratch directory.
+    preview ENTRY         Print the details of a picker entry.
+    path-complete [NAME]  Print cached project paths matching a name.
+    nvim-picker           Print projects as JSON for editor pickers.
+    kill [SESSION]        Kill a session, keeping its layout for undo.
+    undo                  Recreate the most recently killed session.
+    lock [SESSION]        Only attach read-only and guard against kills.
+    mirror [SESSION]      Attach read-only, following switches made by tsm.
+    unlock [SESSION]      Remove a session's lock.
+    archive PROJECT       Hide a project from the picker and from resume.
+    unarchive PROJECT     List an archived project again.
+    copy-env [OPTIONS]    Refresh SSH and display variables in a session.
+    status [SESSION]      Print a one-line summary for the tmux status bar.
+    resume                Recreate the previous day's sessions.
+    bootstrap             Create the sessions of anchors and pinned projects.
+    shell-init SHELL      Print a hook that offers to switch sessions on cd.
+    plugin-init           Bind keys and install hooks in the tmux server.
+    init [DIR...]         Add base dirs to the config.
+    add [PATH]            Register a project directory (default: cwd).
+    create [NAME]         Create a project directory under a base dir and open it.
+    remove NAME|PATH      Unregister a project.
+    mv OLD NEW            Update a session and records after moving a project.
+    exec PROJECT -- CMD   Run a command in a new window of a project's session.
+    move-window [PROJECT] Move the current window to another project's session.
+    run-template T [DIR]  Add a template's missing windows to a session.
+    up [OPTIONS]          Create the sessions declared in a manifest.
+    workspace up NAME     Create the sessions of a configured workspace.
+    last                  Switch to the previously used session.
+    prev                  Step back through the history of switches.
+    next                  Step forward again after prev.
+    history [OPTIONS]     Show the history of switches.
+    time report [OPTIONS] Summarize the time spent in each session.
+    save [SESSION...]     Save the layout of running sessions.
+    restore [SESSION...]  Recreate saved sessions that are not running.
+    export [SESSION]      Print a session's layout as a shareable file.
+    import FILE [DIR]     Create a session from an exported layout.
+    events [--follow]     Print session events as JSON lines.
+    config schema         Print a JSON Schema of the config file.
+    diff-config [--json]  Print the settings set in the config files and where.
+    state gc [OPTIONS]    Review and purge records of deleted projects.
+    templates sync [URL]  Clone or pull a repository of shared templates.
+    serve [OPTIONS]       Serve the JSON-RPC control API on a unix socket.
 
 OPTIONS:
+    --spawn-terminal      Open the session in a new terminal window.
+    --menu                Pick a pinned or recent project from a tmux menu.
+    --stdin               Pick from the paths read from stdin.
+    --print               Print the picked path instead of switching to it.
+    --choose-template     Pick the template of a new session.
+    --source LIST         Only list projects from these sources, e.g. scan,ghq.
+    --archived            Only list archived projects.
     -h, --help            Show this help message.
 `
 
 func main() {
 	if err := run(); err != nil {
+		var exitErr exitCodeError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
+
 		fmt.Println(err.Error())
+		// Failures to reach the tmux server surface as generic errors of
+		// whichever tmux command ran first.
+		if errors.As(err, new(tmuxError)) {
+			if problem := tmuxSocketProblem(); problem != "" {
+				fmt.Println(problem)
+			}
+		}
 		os.Exit(1)
 	}
 }
 
 func run() error {
+	spawnTerminal := flag.Bool("spawn-terminal", false, "")
+	menu := flag.Bool("menu", false, "")
+	fromStdin := flag.Bool("stdin", false, "")
+	printTarget := flag.Bool("print", false, "")
+	chooseTemplate := flag.Bool("choose-template", false, "")
+	sources := flag.String("source", "", "")
+	archived := flag.Bool("archived", false, "")
 	flag.Usage = func() { fmt.Print(AppUsage) }
 	flag.Parse()
 
+	// Completion must be fast and only reads the project cache.
+	if flag.Arg(0) == "path-complete" {
+		return handlePathComplete(flag.Args()[1:])
+	}
+
 	configPath, err := getConfigPath()
 	if err != nil {
 		return err
@@ -52,19 +143,423 @@ func run() error {
 		return err
 	}
 
+	if *spawnTerminal {
+		config.SpawnTerminal = true
+	}
+	config.stdinCandidates = *fromStdin
+	config.printTarget = *printTarget
+	config.chooseTemplate = *chooseTemplate
+	config.showArchived = *archived
+
+	err = selectSources(&config, *sources)
+	if err != nil {
+		return err
+	}
+
+	if config.StateDir != "" {
+		stateDirOverride = expandHome(config.StateDir)
+		if !path.IsAbs(stateDirOverride) {
+			return fmt.Errorf("tsm: state_dir must be an absolute path: %q", config.StateDir)
+		}
+	}
+	err = selectStateBackend(config)
+	if err != nil {
+		return err
+	}
+	warnPermissions(configPath)
+
+	err = checkTimeouts(config)
+	if err != nil {
+		return err
+	}
+	cancelOnInterrupt()
+
+	err = selectMultiplexer(config)
+	if err != nil {
+		return err
+	}
+	if *menu && flag.NArg() == 0 {
+		err = checkMultiplexerCommand("menu")
+	} else {
+		err = checkMultiplexerCommand(flag.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+
+	if config.AutoResume && usingTmux() && !config.printTarget && flag.Arg(0) != "resume" && tmuxServerFresh() {
+		err = handleResume(config)
+		if err != nil && !errors.Is(err, errNothingToResume) {
+			return err
+		}
+	}
+
+	if *menu && flag.NArg() == 0 {
+		err = handleMenu(config)
+	} else {
+		err = runSubcommand(configPath, config)
+	}
+
+	// Whatever failed after an interrupt failed because of it.
+	if runContext.Err() != nil {
+		return errInterrupted
+	}
+
+	// Recording is best effort and must neither mask the command's own
+	// error nor fail it.
+	if !config.printTarget && !slices.Contains(unrecordedCommands, flag.Arg(0)) {
+		if recordErr := recordRunningSessions(); recordErr != nil {
+			fmt.Fprintln(stdIO.Stderr, recordErr)
+		}
+	}
+
+	return err
+}
+
+// unrecordedCommands are the subcommands after which the running sessions
+// are not recorded, as they only read or run too often, e.g. status from the
+// status bar and time from tmux hooks.
+var unrecordedCommands = []string{
+	"which", "why", "list", "preview", "nvim-picker", "status", "shell-init", "auto-switch",
+	"prefetch", "history", "time", "events", "config", "diff-config", "anchors", "export",
+}
+
+func runSubcommand(configPath string, config Config) error {
 	switch flag.Arg(0) {
-	case "0":
-		return handleSwitchToZero()
+	case "switch":
+		return handleSwitch(config, flag.Args()[1:])
+	case "which":
+		return handleWhich(flag.Args()[1:])
+	case "edit":
+		return handleEdit(config, flag.Args()[1:])
+	case "tmp":
+		return handleTmp(config, flag.Args()[1:])
+	case "preview":
+		return handlePreview(config, flag.Args()[1:])
+	case "list":
+		return handleList(config, flag.Args()[1:])
+	case "ui":
+		return handleUI(config, flag.Args()[1:])
+	case "nvim-picker":
+		return handleNvimPicker(config)
+	case "kill":
+		return handleKill(config, flag.Args()[1:])
+	case "undo":
+		return handleUndo(config)
+	case "plugin-init":
+		return handlePluginInit(config, flag.Args()[1:])
+	case "bootstrap":
+		return handleBootstrap(config, flag.Args()[1:])
+	case "find":
+		return handleFind(config, flag.Args()[1:])
+	case "mirror":
+		return handleMirror(flag.Args()[1:])
+	case "copy-env":
+		return handleCopyEnv(config, flag.Args()[1:])
+	case "archive":
+		return handleArchive(config, flag.Args()[1:], true)
+	case "unarchive":
+		return handleArchive(config, flag.Args()[1:], false)
+	case "lock":
+		return handleLock(flag.Args()[1:], true)
+	case "unlock":
+		return handleLock(flag.Args()[1:], false)
+	case "status":
+		return handleStatus(config, flag.Args()[1:])
+	case "resume":
+		return handleResume(config)
+	case "shell-init":
+		return handleShellInit(flag.Args()[1:])
+	case "auto-switch":
+		return handleAutoSwitch(config, flag.Args()[1:])
+	case "prefetch":
+		return handlePrefetch(config, flag.Args()[1:])
+	case "provision":
+		return handleProvision(config, flag.Args()[1:])
+	case "init":
+		return handleInit(configPath, config, flag.Args()[1:])
+	case "add":
+		return handleAdd(configPath, flag.Args()[1:])
+	case "create":
+		return handleCreate(config, flag.Args()[1:])
+	case "sub":
+		return handleSub(config, flag.Args()[1:])
+	case "remove":
+		return handleRemove(configPath, flag.Args()[1:])
+	case "mv":
+		return handleMv(configPath, config, flag.Args()[1:])
+	case "why":
+		return handleWhy(config, flag.Args()[1:])
+	case "move-window":
+		return handleMoveWindow(config, flag.Args()[1:])
+	case "exec":
+		return handleExec(config, flag.Args()[1:])
+	case "run-template":
+		return handleRunTemplate(config, flag.Args()[1:])
+	case "up":
+		return handleUp(config, flag.Args()[1:])
+	case "workspace":
+		return handleWorkspace(configPath, config, flag.Args()[1:])
+	case "last":
+		return handleLast(config)
+	case "prev":
+		return handleHistoryStep(config, -1)
+	case "next":
+		return handleHistoryStep(config, 1)
+	case "history":
+		return handleHistory(flag.Args()[1:])
+	case "time":
+		return handleTime(config, flag.Args()[1:])
+	case "save":
+		return handleSave(flag.Args()[1:])
+	case "restore":
+		return handleRestore(flag.Args()[1:])
+	case "export":
+		return handleExport(flag.Args()[1:])
+	case "import":
+		return handleImport(config, flag.Args()[1:])
+	case "events":
+		return handleEvents(flag.Args()[1:])
+	case "config":
+		return handleConfig(flag.Args()[1:])
+	case "diff-config":
+		return handleDiffConfig(configPath, config, flag.Args()[1:])
+	case "templates":
+		return handleTemplates(configPath, flag.Args()[1:])
+	case "state":
+		return handleState(configPath, config, flag.Args()[1:])
+	case "serve":
+		return handleServe(configPath, config, flag.Args()[1:])
+	case "anchors":
+		return handleAnchors(config)
 	default:
-		return handleSessionSwitch(config)
+		if anchor, ok := lookupAnchor(config, flag.Arg(0)); ok {
+			return handleAnchor(config, flag.Arg(0), anchor)
+		}
+
+		if query := strings.Join(flag.Args(), " "); query != "" {
+			// A query narrowing the picker to a group opens it.
+			if group, _ := splitGroupFilter(config, query); group != "" {
+				config.pickerQuery = query
+				return handleSessionSwitch(configPath, config)
+			}
+
+			targetDir, err := matchProjectExactly(config, query)
+			if err != nil {
+				return err
+			} else if config.printTarget {
+				return printTargetDir(targetDir)
+			}
+
+			return switchToProject(config, targetDir)
+		}
+
+		return handleSessionSwitch(configPath, config)
 	}
 }
 
 type Config struct {
+	// Include lists config files layered on top of this one. See
+	// loadConfigLayers for the merge semantics.
+	Include []string `json:"include,omitempty"`
+
 	BaseDirs   []string `json:"base_dirs"`
 	IgnoreDirs []string `json:"ignore_dirs"`
+	OnConflict string   `json:"on_conflict,omitempty"`
+	// ShowHidden lists dot-directories found in base dirs, which are
+	// otherwise skipped.
+	ShowHidden bool `json:"show_hidden,omitempty"`
+	// BaseDirLimit is the number of entries read from each base dir, so
+	// that a base dir such as ~ cannot stall discovery. It defaults to
+	// defaultBaseDirLimit, and a negative limit reads every entry.
+	BaseDirLimit int `json:"base_dir_limit,omitempty"`
+	// Sources list project directories in addition to base dirs. See the
+	// Source constants.
+	Sources []string `json:"sources,omitempty"`
+	// SourceWeights order the origins of picker entries, e.g.
+	// {"ghq": 2}. Origins without a weight weigh 1, and heavier origins
+	// are listed first. See the Origin constants.
+	SourceWeights map[string]float64 `json:"source_weights,omitempty"`
+	// FileTargets list the kinds of files in project directories that the
+	// picker offers as entries of their own.
+	FileTargets []FileTarget `json:"file_targets,omitempty"`
+	// GitHub configures the gh source.
+	GitHub GitHubConfig `json:"github"`
+
+	// Projects are explicitly registered project directories.
+	Projects []ProjectConfig `json:"projects,omitempty"`
+	// Remotes are tmux servers reachable over SSH, given as
+	// "ssh://[user@]host[:port]", whose sessions are listed in the picker.
+	Remotes []string `json:"remotes,omitempty"`
+
+	Picker PickerConfig `json:"picker"`
+	// Timeouts bound slow operations, e.g. {"discovery": "3s"}. See the
+	// Timeout constants.
+	Timeouts map[string]string `json:"timeouts,omitempty"`
+
+	// Create sets up the projects created with create.
+	Create CreateConfig `json:"create"`
+
+	// Sub finds the components of a repository for sub.
+	Sub SubConfig `json:"sub"`
+
+	// Anchors are named sessions with fixed directories, reachable as
+	// "tsm NAME".
+	Anchors map[string]Anchor `json:"anchors,omitempty"`
+
+	// Workspaces are named manifests brought up with "tsm workspace up".
+	Workspaces map[string]Manifest `json:"workspaces,omitempty"`
+
+	Templates map[string]Template `json:"templates,omitempty"`
+	// Windows are shared window definitions that template windows can use.
+	Windows map[string]WindowTemplate `json:"windows,omitempty"`
+	// DefaultTemplate names the template applied to newly created sessions.
+	DefaultTemplate string `json:"default_template,omitempty"`
+
+	// Terminal is the command used to open a new terminal window, e.g.
+	// "alacritty -e". The tmux attach command is appended to it.
+	Terminal string `json:"terminal,omitempty"`
+	// SpawnTerminal opens sessions in a new terminal window instead of
+	// attaching or switching in place.
+	SpawnTerminal bool `json:"spawn_terminal,omitempty"`
+
+	// SessionNaming selects how session names are derived from project
+	// directories. It defaults to NamingBasename.
+	SessionNaming string `json:"session_naming,omitempty"`
+	// WindowNaming is the default window naming rule of templates. See the
+	// WindowNaming constants.
+	WindowNaming string `json:"window_naming,omitempty"`
+	// Sanitize controls how disallowed characters in session names are
+	// handled.
+	Sanitize SanitizeConfig `json:"sanitize"`
+
+	// StatusTTL is how long the output of the status command is cached.
+	StatusTTL string `json:"status_ttl,omitempty"`
+	// UndoGrace is how long a killed session can be restored with undo.
+	UndoGrace string `json:"undo_grace,omitempty"`
+
+	// Editor is the command started by the edit command. It defaults to
+	// $VISUAL or $EDITOR.
+	Editor string `json:"editor,omitempty"`
+
+	// Shell is run in place of the default shell in new session panes,
+	// e.g. "fish" or "nix develop".
+	Shell string `json:"shell,omitempty"`
+	// SessionOptions are tmux options set on every new session.
+	SessionOptions map[string]string `json:"session_options,omitempty"`
+	// NewSessionArgs are extra arguments of tmux new-session, such as
+	// "-x" and "-y", used when creating project sessions.
+	NewSessionArgs []string `json:"new_session_args,omitempty"`
+
+	// AutoResume runs the resume command whenever tsm finds the tmux server
+	// without any sessions.
+	AutoResume bool `json:"auto_resume,omitempty"`
+
+	// AutoSwitch controls the shell hook installed by shell-init. It is one
+	// of the AutoSwitch constants and defaults to AutoSwitchPrompt.
+	AutoSwitch string `json:"auto_switch,omitempty"`
+
+	// CopyEnv lists the variables copy-env refreshes in a session. It
+	// defaults to defaultCopyEnv.
+	CopyEnv []string `json:"copy_env,omitempty"`
+
+	// Notify is a shell command run when a session created from an async
+	// template is ready, with TSM_SESSION and TSM_MESSAGE set. The message
+	// is displayed on every tmux client by default.
+	Notify string `json:"notify,omitempty"`
+
+	// Autosave is how often serve saves the layouts of the running
+	// sessions, e.g. "5m". Sessions are not saved automatically when it is
+	// empty.
+	Autosave string `json:"autosave,omitempty"`
+
+	// Mode is one of the Mode constants and defaults to ModeSessions.
+	Mode string `json:"mode,omitempty"`
+	// ModeSession names the session holding the project windows in
+	// ModeWindows. It defaults to defaultModeSession.
+	ModeSession string `json:"mode_session,omitempty"`
+
+	// AutoFetch is how often repositories are fetched in the background
+	// when their session is switched to, e.g. "1h". Fetching is off when
+	// empty.
+	AutoFetch string `json:"auto_fetch,omitempty"`
+
+	// IdleCap is the longest stretch of uninterrupted time the time report
+	// attributes to a session.
+	IdleCap string `json:"idle_cap,omitempty"`
+
+	// Prefetch creates the sessions of the projects likely to be switched
+	// to next in the background after every switch. It is off when nil.
+	Prefetch *PrefetchConfig `json:"prefetch,omitempty"`
+
+	// Multiplexer is one of the Multiplexer constants and defaults to
+	// MultiplexerTmux.
+	Multiplexer string `json:"multiplexer,omitempty"`
+
+	// StateDir replaces the state directory, e.g. to keep state off a home
+	// directory shared between machines. A leading ~ is expanded.
+	StateDir string `json:"state_dir,omitempty"`
+	// StateBackend is one of the StateBackend constants and defaults to
+	// StateBackendJSON.
+	StateBackend string `json:"state_backend,omitempty"`
+
+	// stdinCandidates makes the picker list the paths read from stdin
+	// instead of discovering projects.
+	stdinCandidates bool
+	// pickerQuery is the initial query of the picker.
+	pickerQuery string
+	// printTarget makes the picker print the selected entry instead of
+	// switching to it.
+	printTarget bool
+	// chooseTemplate offers every template when a session is created.
+	chooseTemplate bool
+	// nonInteractive keeps tsm from asking anything, e.g. while serving
+	// requests from a terminal.
+	nonInteractive bool
+	// showArchived makes listings hold only archived projects, instead of
+	// leaving them out.
+	showArchived bool
+	// onlySources lists the only origins of picker entries to walk, as
+	// given with --source.
+	onlySources []string
+}
+
+type PickerConfig struct {
+	// FzfArgs are appended to the fzf command line.
+	FzfArgs []string `json:"fzf_args,omitempty"`
+	// FzfDefaultOpts replaces FZF_DEFAULT_OPTS when running the picker. A
+	// nil value leaves the environment untouched while an empty string
+	// clears it.
+	FzfDefaultOpts *string `json:"fzf_default_opts,omitempty"`
+	// Git lists the indicators shown next to repositories. See the Git
+	// constants.
+	Git []string `json:"git,omitempty"`
+	// Group labels entries with their group, one of the Group constants.
+	// Entries are not grouped when it is empty.
+	Group string `json:"group,omitempty"`
+	// Preview shows the details of the highlighted entry in a preview
+	// pane. Enrichment that is too slow for every entry, namely listing
+	// the sessions of remotes and counting commits ahead of and behind
+	// upstream, is then only done in the preview.
+	Preview *PreviewConfig `json:"preview,omitempty"`
 }
 
+// Strategies for deriving a session name from a project directory.
+const (
+	NamingBasename  = "basename"
+	NamingGitRemote = "git_remote"
+)
+
+// Policies for handling an existing session whose directory does not match
+// the selected project.
+const (
+	ConflictPrompt   = "prompt"
+	ConflictAttach   = "attach"
+	ConflictRename   = "rename"
+	ConflictRecreate = "recreate"
+	ConflictFail     = "fail"
+)
+
 func getConfigPath() (string, error) {
 	configPath, err := os.UserConfigDir()
 	if err != nil {
@@ -74,22 +569,111 @@ func getConfigPath() (string, error) {
 	return path.Join(configPath, "tsm", "config.json"), nil
 }
 
+// stateDirOverride is the state directory set with state_dir, if any.
+var stateDirOverride string
+
+// getStateDir returns the directory holding tsm's state, $XDG_STATE_HOME/tsm
+// or ~/.local/state/tsm unless state_dir is set.
+func getStateDir() (string, error) {
+	if stateDirOverride != "" {
+		return stateDirOverride, nil
+	}
+
+	stateDir := os.Getenv("XDG_STATE_HOME")
+	if !path.IsAbs(stateDir) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		stateDir = path.Join(home, ".local", "state")
+	}
+
+	return path.Join(stateDir, "tsm"), nil
+}
+
+// getStatePath returns the path of a file that tsm maintains in its state
+// directory. State is specific to the machine and kept apart from the config
+// so that the config can be synced between machines. Files of older
+// versions, which kept state alongside the config file, are moved there on
+// first use.
+func getStatePath(name string) (string, error) {
+	stateDir, err := getStateDir()
+	if err != nil {
+		return "", err
+	}
+	statePath := path.Join(stateDir, name)
+	if stateDirOverride != "" {
+		return statePath, nil
+	}
+
+	// Only files are moved, as the legacy directory is the config
+	// directory.
+	if configDir, err := os.UserConfigDir(); err == nil && name != "" && !fileExists(statePath) {
+		legacyPath := path.Join(configDir, "tsm", name)
+		if info, err := os.Stat(legacyPath); err == nil && info.Mode().IsRegular() {
+			if err := os.MkdirAll(path.Dir(statePath), 0700); err != nil {
+				return "", err
+			}
+			if err := os.Rename(legacyPath, statePath); err != nil {
+				return "", err
+			}
+			os.Remove(legacyPath + ".lock")
+		}
+	}
+
+	return statePath, nil
+}
+
+// readConfig loads the config, printing warnings about unknown keys.
 func readConfig(configPath string) (Config, error) {
-	f, err := os.ReadFile(configPath)
-	if errors.Is(err, os.ErrNotExist) {
+	config, warnings, err := loadConfig(configPath)
+	for _, warning := range warnings {
+		fmt.Fprintf(stdIO.Stderr, "tsm: config: %s\n", warning)
+	}
+
+	return config, err
+}
+
+// loadConfig reads the config file and the files it includes, creating an
+// empty config if there is none. The merged config is validated, returning
+// any unknown keys as warnings.
+func loadConfig(configPath string) (Config, []string, error) {
+	layers, err := loadConfigLayers(configPath, nil, nil)
+	if errors.Is(err, os.ErrNotExist) && !fileExists(configPath) {
 		c := Config{BaseDirs: []string{}, IgnoreDirs: []string{}}
-		return c, writeConfig(configPath, c)
+		return c, nil, writeConfig(configPath, c)
 	} else if err != nil {
-		return Config{}, err
+		return Config{}, nil, err
+	}
+
+	warnings, err := validateConfig(layers)
+	if err != nil {
+		return Config{}, warnings, fmt.Errorf("tsm: config: %w", err)
+	}
+
+	err = applyHostOverrides(layers)
+	if err != nil {
+		return Config{}, warnings, err
+	}
+
+	f, err := json.Marshal(layers)
+	if err != nil {
+		return Config{}, warnings, err
 	}
 
 	var config Config
 	err = json.Unmarshal(f, &config)
 	if err != nil {
-		return Config{}, err
+		return Config{}, warnings, err
 	}
+	warnings = append(warnings, loadSharedTemplates(configPath, &config)...)
+
+	return config, warnings, nil
+}
 
-	return config, nil
+func fileExists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
 }
 
 func writeConfig(configPath string, config Config) error {
@@ -98,175 +682,1201 @@ func writeConfig(configPath string, config Config) error {
 		return err
 	}
 
-	return os.WriteFile(configPath, d, 0644)
-}
-
-func handleSessionSwitch(config Config) error {
-	targetDir, err := getTargetDir(config)
+	err = os.MkdirAll(path.Dir(configPath), 0700)
 	if err != nil {
 		return err
-	} else if targetDir == "" {
-		return nil
 	}
 
-	id := cleanID(path.Base(targetDir))
+	return writeFileAtomic(configPath, d, 0600)
+}
 
-	if !sessionExists(id) {
-		err = createSession(id, targetDir)
+func handleSessionSwitch(configPath string, config Config) error {
+	var targetDir string
+	for {
+		key, target, err := getTargetDir(config)
+		if errors.Is(err, errNoEntries) {
+			return emptyPickerError(configPath, config)
+		} else if err != nil {
+			return err
+		} else if key == pickerKeyCreate {
+			targetDir, err = createProject(config, "", target)
+			if err != nil || targetDir == "" {
+				return err
+			}
+			break
+		} else if target == "" {
+			return nil
+		}
+
+		// Pull requests are checked out before anything is done with them.
+		if pr, ok := parsePullRequest(target); ok && (key == "" || key == pickerKeyEdit) {
+			target, err = checkoutPullRequest(config, pr)
+			if err != nil {
+				return err
+			}
+		}
+
+		if key == "" {
+			targetDir = target
+			break
+		} else if key == pickerKeyEdit {
+			return editProject(config, target)
+		} else if key == pickerKeyMove {
+			return moveWindow(config, target, true)
+		}
+
+		// The picker is reopened after any other action.
+		err = handlePickerAction(config, key, target)
 		if err != nil {
 			return err
 		}
 	}
 
-	err = switchToSession(id)
+	if config.printTarget {
+		return printTargetDir(targetDir)
+	}
+
+	if remote, ok := parseRemoteSession(targetDir); ok {
+		return switchToRemoteSession(config, remote)
+	} else if ft, ok := findFileTarget(config, targetDir); ok {
+		return switchToFile(config, targetDir, ft)
+	}
+
+	err := validateTarget(targetDir)
 	if err != nil {
+		// Stale entries only stay in the picker if they were saved.
+		if forgetErr := forgetTarget(configPath, config, targetDir); forgetErr != nil {
+			return forgetErr
+		}
+
 		return err
 	}
 
-	return nil
+	return switchToProject(config, targetDir)
 }
 
-func handleSwitchToZero() error {
-	id := "0"
-	targetDir, err := os.UserHomeDir()
-	if err != nil {
-		return err
+// printTargetDir prints the selected entry for use by shell functions, e.g.
+// cd "$(tsm --print)".
+func printTargetDir(targetDir string) error {
+	_, err := fmt.Fprintln(stdIO.Stdout, targetDir)
+	return err
+}
+
+func handleSwitch(config Config, args []string) error {
+	flags := flag.NewFlagSet("switch", flag.ExitOnError)
+	onConflict := flags.String("on-conflict", config.OnConflict, "")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return errors.New("tsm: switch requires a project path or name")
 	}
 
-	if !sessionExists(id) {
-		err = createSession(id, targetDir)
+	// Directories named without a slash are still found before projects.
+	targetDir := flags.Arg(0)
+	if validateTarget(targetDir) != nil {
+		var err error
+		targetDir, err = resolveProject(config, targetDir)
 		if err != nil {
 			return err
 		}
 	}
 
-	err = switchToSession(id)
+	config.OnConflict = *onConflict
+	if ft, ok := findFileTarget(config, targetDir); ok {
+		return switchToFile(config, targetDir, ft)
+	}
+
+	return switchToProject(config, targetDir)
+}
+
+// handleWhich prints the project directory of a session, the current one by
+// default.
+func handleWhich(args []string) error {
+	id, err := sessionArg(args)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	if !sessionExists(id) {
+		return fmt.Errorf("tsm: session %q does not exist", id)
+	}
 
-}
+	sessionDir, err := sessionPath(id)
+	if err != nil {
+		return err
+	}
 
-type IO struct {
-	Stdin  io.Reader
-	Stdout io.Writer
-	Stderr io.Writer
+	fmt.Fprintln(stdIO.Stdout, sessionDir)
+	return nil
 }
 
-var stdIO = IO{
-	Stdin:  os.Stdin,
-	Stdout: os.Stdout,
-	Stderr: os.Stderr,
-}
+// handleNvimPicker prints the discovered projects as a JSON array so that
+// editor pickers such as Telescope or fzf-lua can list them and call back
+// into the switch command.
+func handleNvimPicker(config Config) error {
+	projects, err := listProjects(config)
+	if err != nil {
+		return err
+	}
 
-func characterAllowed(r rune) bool {
-	return (r >= 'a' && r <= 'z') ||
-		(r >= 'A' && r <= 'Z') ||
-		(r >= '0' && r <= '9') ||
-		r == '-' ||
-		r == '_'
+	return json.NewEncoder(stdIO.Stdout).Encode(projects)
 }
-func cleanID(id string) string {
-	idSlice := []rune(id)
-	for i, r := range idSlice {
-		if !characterAllowed(r) {
-			idSlice[i] = '_'
-		}
-	}
 
-	return string(idSlice)
+// ensureSession creates a session for targetDir if one does not already
+// exist and returns its ID. An empty ID is returned if the user cancels while
+// resolving a conflicting session.
+func ensureSession(config Config, targetDir string) (string, error) {
+	name, _ := sessionName(config, targetDir)
+	return ensureNamedSession(config, targetDir, name)
 }
 
-func getTargetDir(config Config) (string, error) {
-	paths, err := listDirectories(config)
+// ensureNamedSession is ensureSession for a session named name, before it is
+// sanitized, rather than after the project directory. Invocations for the
+// same project, e.g. from a key binding pressed repeatedly, take turns, so
+// that the session is created once and the others find it.
+func ensureNamedSession(config Config, targetDir, name string) (string, error) {
+	err := validateTarget(targetDir)
 	if err != nil {
 		return "", err
 	}
 
-	out := bytes.NewBuffer([]byte{})
-	err = runCommand(IO{
-		Stdin:  strings.NewReader(strings.Join(paths, "\n")),
-		Stdout: out,
-		Stderr: os.Stderr,
-	}, "fzf")
+	unlock, err := lockProject(targetDir)
 	if err != nil {
-		return "", nil
+		return "", err
 	}
+	defer unlock()
 
-	return strings.TrimSpace(out.String()), nil
+	// A session of the same name may still be created by something other
+	// than tsm in between, in which case the session is looked up again.
+	for attempt := 1; ; attempt++ {
+		id, err := ensureSessionOnce(config, targetDir, name)
+		if !errors.Is(err, errDuplicateSession) || attempt == createAttempts {
+			return id, err
+		}
+	}
 }
 
-func listDirectories(config Config) ([]string, error) {
-	var paths []string
-	for _, baseDir := range config.BaseDirs {
-		d, err := os.ReadDir(baseDir)
+// createAttempts is how often ensureNamedSession tries to create a session
+// that keeps being created by others in the meantime.
+const createAttempts = 3
+
+func ensureSessionOnce(config Config, targetDir, name string) (string, error) {
+	// A session created for the project may have been renamed in tmux since.
+	if id, ok := findSessionForPath(targetDir); ok {
+		return id, nil
+	}
+
+	id := cleanID(config, name)
+
+	var err error
+	if sessionExists(id) {
+		id, err = resolveConflict(config, id, targetDir)
 		if err != nil {
-			return nil, err
+			return "", err
+		} else if id == "" {
+			return "", nil
 		}
+	}
 
-		for _, entry := range d {
-			if !entry.IsDir() {
-				continue
-			}
+	if !sessionExists(id) {
+		err = createProjectSession(config, id, targetDir)
+		if err != nil {
+			return "", err
+		}
 
-			paths = append(paths, path.Join(baseDir, entry.Name()))
+		if name != id {
+			fmt.Fprintf(stdIO.Stderr, "tsm: created session %q for %q\n", id, name)
 		}
 	}
 
-	return removeIgnoredDirs(paths, config), nil
+	return id, nil
 }
 
-func removeIgnoredDirs(paths []string, config Config) []string {
-	return slices.DeleteFunc(paths, func(path string) bool {
-		for _, d := range config.IgnoreDirs {
-			if strings.HasSuffix(path, d) {
-				return true
-			}
-		}
+// lockProject takes the lock guarding the creation of a project's session,
+// blocking while another tsm process holds it.
+func lockProject(dir string) (unlock func(), err error) {
+	lockPath, err := getStatePath(path.Join("locks", fmt.Sprintf("%08x", nameHash(canonicalPath(dir)))))
+	if err != nil {
+		return nil, err
+	}
 
-		return false
-	})
+	return lockFile(lockPath)
 }
 
-func sessionExists(id string) bool {
-	err := runCommand(IO{}, "tmux", "has-session", "-t", id)
-	return err == nil
-}
+// createProjectSession creates a detached session for a project directory and
+// applies the project's template, or the default template, to it.
+func createProjectSession(config Config, id, targetDir string) error {
+	templateName, err := chooseProjectTemplate(config, targetDir)
+	if err != nil {
+		return err
+	}
+
+	var t Template
+	if templateName != "" {
+		t, err = lookupTemplate(config, templateName)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = createTemplateSession(config, id, targetDir, t)
+	if err != nil || templateName == "" || !usingTmux() {
+		return err
+	}
 
-func createSession(id, targetDir string) error {
-	return runCommand(IO{}, "tmux", "new-session", "-d", "-s", id, "-c", targetDir)
+	return runCommand(IO{}, "tmux", "set-option", "-t", id, templateOption, templateName)
 }
 
-func switchToSession(id string) error {
-	if _, ok := os.LookupEnv("TMUX"); ok {
-		return switchSession(id)
+// projectTemplate returns the name of the template used for new sessions of a
+// project directory, if any.
+func projectTemplate(config Config, targetDir string) string {
+	if p, ok := findProject(config, targetDir); ok && p.Template != "" {
+		return p.Template
 	}
 
-	return attachToSession(id)
+	return config.DefaultTemplate
 }
 
-func attachToSession(id string) error {
-	return runCommand(stdIO, "tmux", "attach", "-t", id)
+// createTemplateSession creates a detached session for a project directory
+// from an already resolved template.
+func createTemplateSession(config Config, id, targetDir string, t Template) error {
+	return mux.CreateSession(config, id, targetDir, t)
 }
 
-func switchSession(id string) error {
-	return runCommand(stdIO, "tmux", "switch-client", "-t", id)
+// resolveConflict determines which session should be used when a session
+// named id already exists. If the existing session is rooted in targetDir,
+// then id is returned unchanged. Otherwise the configured conflict policy is
+// applied. An empty ID is returned if the user cancels.
+func resolveConflict(config Config, id, targetDir string) (string, error) {
+	existingDir, err := sessionPath(id)
+	if err != nil {
+		return "", err
+	}
+
+	if path.Clean(existingDir) == path.Clean(targetDir) {
+		return id, nil
+	}
+
+	policy := config.OnConflict
+	if policy == "" || policy == ConflictPrompt {
+		policy, err = promptConflict(id, existingDir, targetDir)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	switch policy {
+	case ConflictAttach:
+		return id, nil
+	case ConflictRename:
+		return nextFreeID(id), nil
+	case ConflictRecreate:
+		ok, err := confirmUnlocked(id, "Kill")
+		if err != nil || !ok {
+			return "", err
+		}
+
+		return id, trashSession(config, id)
+	case ConflictFail:
+		return "", fmt.Errorf("tsm: session %q already exists for %s", id, existingDir)
+	case "":
+		return "", nil
+	default:
+		return "", fmt.Errorf("tsm: unknown on_conflict policy %q", policy)
+	}
 }
 
-func runCommand(inOut IO, command ...string) error {
-	if len(command) == 0 {
-		panic("tsm: empty command provided")
+func promptConflict(id, existingDir, targetDir string) (string, error) {
+	fmt.Fprintf(stdIO.Stderr, "Session %q already exists for %s, not %s.\n", id, existingDir, targetDir)
+
+	answer, err := prompt("[a]ttach anyway, [r]ename new session, [k]ill and recreate, [c]ancel: ")
+	if err != nil {
+		return "", err
+	}
+
+	switch strings.ToLower(answer) {
+	case "a", "attach":
+		return ConflictAttach, nil
+	case "r", "rename":
+		return ConflictRename, nil
+	case "k", "kill", "recreate":
+		return ConflictRecreate, nil
+	default:
+		return "", nil
 	}
+}
 
-	cmd := exec.Command(command[0], command[1:]...)
+// stdinReader is shared by every prompt so that answers buffered along with
+// an earlier one are not lost when several questions are asked in a row.
+var stdinReader = sync.OnceValue(func() *bufio.Reader {
+	return bufio.NewReader(stdIO.Stdin)
+})
 
-	cmd.Stdin = inOut.Stdin
-	cmd.Stdout = inOut.Stdout
-	cmd.Stderr = inOut.Stderr
+// prompt asks the user a question on stderr and returns the trimmed answer
+// read from stdin.
+func prompt(format string, a ...any) (string, error) {
+	fmt.Fprintf(stdIO.Stderr, format, a...)
 
-	err := cmd.Run()
+	answer, err := stdinReader().ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
 
-	return err
+	return strings.TrimSpace(answer), nil
+}
+
+// confirm asks the user a yes or no question. Anything other than yes is
+// treated as no.
+func confirm(format string, a ...any) (bool, error) {
+	answer, err := prompt(format+" [y/N]: ", a...)
+	if err != nil {
+		return false, err
+	}
+
+	answer = strings.ToLower(answer)
+	return answer == "y" || answer == "yes", nil
+}
+
+// nextFreeID appends an increasing numeric suffix to id until no session
+// with the resulting name exists.
+func nextFreeID(id string) string {
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s-%d", id, i)
+		if !sessionExists(candidate) {
+			return candidate
+		}
+	}
+}
+
+type IO struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+var stdIO = IO{
+	Stdin:  os.Stdin,
+	Stdout: os.Stdout,
+	Stderr: os.Stderr,
+}
+
+func characterAllowed(r rune) bool {
+	return (r >= 'a' && r <= 'z') ||
+		(r >= 'A' && r <= 'Z') ||
+		(r >= '0' && r <= '9') ||
+		r == '-' ||
+		r == '_'
+}
+
+func cleanID(config Config, id string) string {
+	return config.Sanitize.clean(id)
+}
+
+// sessionID derives the session name for a project directory. Registered
+// projects use their configured name, otherwise the configured naming strategy
+// is used.
+func sessionID(config Config, targetDir string) string {
+	name, _ := sessionName(config, targetDir)
+	return cleanID(config, name)
+}
+
+// sessionName returns the name of a project directory's session before it is
+// sanitized, along with the rule it was derived by.
+func sessionName(config Config, targetDir string) (name, rule string) {
+	if p, ok := findProject(config, targetDir); ok && p.Name != "" {
+		return p.Name, "name of the registered project"
+	}
+
+	if slices.Contains(config.Sources, SourceGHQ) {
+		if name := ghqName(targetDir); name != "" {
+			return name, "path below the ghq root"
+		}
+	}
+
+	if config.SessionNaming == NamingGitRemote {
+		if name := gitRemoteName(targetDir); name != "" {
+			return name, "origin remote (session_naming git_remote)"
+		}
+	}
+
+	return path.Base(targetDir), "directory name"
+}
+
+// gitRemoteName returns "org-repo" for a repository whose origin remote is
+// org/repo. An empty string is returned if the directory has no origin.
+func gitRemoteName(dir string) string {
+	out, err := runCommandOutput("git", "-C", dir, "remote", "get-url", "origin")
+	if err != nil {
+		return ""
+	}
+
+	remote := strings.TrimSuffix(strings.TrimSpace(out), "/")
+	remote = strings.TrimSuffix(remote, ".git")
+
+	// Normalize scp-like remotes (git@host:org/repo) to use slashes.
+	if i := strings.Index(remote, ":"); i >= 0 && !strings.Contains(remote, "://") {
+		remote = remote[:i] + "/" + remote[i+1:]
+	}
+
+	parts := strings.Split(remote, "/")
+	if len(parts) < 2 || parts[len(parts)-1] == "" {
+		return ""
+	}
+
+	return parts[len(parts)-2] + "-" + parts[len(parts)-1]
+}
+
+// errPickerCancelled makes tsm exit with the status of a cancelled fzf, so
+// that scripts can tell cancelling from failing.
+var errPickerCancelled = exitCodeError{code: 130}
+
+// errNoEntries is returned instead of opening an empty picker.
+var errNoEntries = errors.New("tsm: nothing to pick from")
+
+// errDuplicateSession is returned when tmux refuses to create a session that
+// came into existence after tsm checked for it.
+var errDuplicateSession = errors.New("duplicate session")
+
+// getTargetDir runs the picker and returns the key pressed, empty for enter,
+// and the selected directory, or the query typed for pickerKeyCreate.
+// Directories are streamed to the picker as they are discovered so that it
+// appears immediately, even when scanning many base dirs. Pinned directories
+// are listed first. errPickerCancelled is returned if the user cancels the
+// picker, and errNoEntries if there is nothing to pick from.
+func getTargetDir(config Config) (string, string, error) {
+	// A query starting with a group lists only the group's entries.
+	filter, query := splitGroupFilter(config, config.pickerQuery)
+	config.pickerQuery = query
+
+	// The walk runs ahead of the picker so that the picker is only opened
+	// once there is an entry. Entries are handed over one at a time, and the
+	// walk stops early once the picker exits.
+	lines := make(chan string)
+	stop := make(chan struct{})
+	walkErr := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		walkErr <- walkPickerEntries(config, func(p string) error {
+			group := pickerGroup(config, p)
+			if filter != "" && group != filter {
+				return nil
+			}
+
+			select {
+			case lines <- pickerLine(config, group, p):
+				return nil
+			case <-stop:
+				return os.ErrClosed
+			}
+		})
+	}()
+
+	first, ok := <-lines
+	if !ok {
+		if err := <-walkErr; err != nil {
+			return "", "", err
+		}
+		return "", "", errNoEntries
+	}
+
+	out := bytes.NewBuffer([]byte{})
+	cmd := newCommand(IO{
+		Stdout: out,
+		Stderr: os.Stderr,
+	}, append(pickerCommand(config), config.Picker.FzfArgs...)...)
+	if config.Picker.FzfDefaultOpts != nil {
+		cmd.Env = append(os.Environ(), "FZF_DEFAULT_OPTS="+*config.Picker.FzfDefaultOpts)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		close(stop)
+		return "", "", err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		close(stop)
+		if errors.Is(err, exec.ErrNotFound) {
+			return "", "", errors.New("tsm: fzf not found; install it (https://github.com/junegunn/fzf) or pick from a tmux menu with --menu")
+		}
+		return "", "", fmt.Errorf("tsm: starting fzf: %w", err)
+	}
+
+	// Writes fail once the picker exits, which stops the walk.
+	go func() {
+		defer close(stop)
+		defer stdin.Close()
+		for line, ok := first, true; ok; line, ok = <-lines {
+			_, err := io.WriteString(stdin, line+"\n")
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	err = cmd.Wait()
+	query, rest, _ := strings.Cut(out.String(), "\n")
+	key, target, _ := strings.Cut(strings.TrimRight(rest, "\n"), "\n")
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && key == pickerKeyCreate {
+		// A new project is typed in, which usually matches nothing.
+		err = nil
+	}
+	if errors.As(err, &exitErr) && (exitErr.ExitCode() == 130 || exitErr.ExitCode() == 1) {
+		// fzf exits with 130 when cancelled and with 1 when nothing matched.
+		return "", "", errPickerCancelled
+	} else if err != nil {
+		return "", "", fmt.Errorf("tsm: fzf failed: %w", err)
+	}
+
+	select {
+	case err := <-walkErr:
+		if err != nil && !errors.Is(err, os.ErrClosed) && !errors.Is(err, syscall.EPIPE) {
+			return "", "", err
+		}
+	default:
+	}
+
+	if key == pickerKeyCreate {
+		return key, strings.TrimSpace(query), nil
+	} else if target == "" {
+		// Without a selection, fzf only prints the key.
+		return "", "", nil
+	}
+
+	// Anything but the path is only displayed.
+	target, _, _ = strings.Cut(target, "\t")
+	return key, strings.TrimSpace(stripGroupLabel(target)), nil
+}
+
+func listDirectories(config Config) ([]string, error) {
+	var paths []string
+	err := walkDirectories(config, func(p string) error {
+		paths = append(paths, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return paths, nil
+}
+
+// walkDirectories calls fn for every project directory as it is discovered.
+// By default, registered projects are visited first and are never ignored,
+// followed by the children of each base dir in the configured order and then
+// the directories listed by sources. source_weights reorders them and
+// --source leaves some out. A directory reachable in several ways, e.g.
+// through a symlink or from both a base dir and a source, is only visited the
+// first time, so earlier origins take precedence. Walking stops at the first
+// error returned by fn.
+func walkDirectories(config Config, fn func(string) error) error {
+	return walkOrigins(config, slices.DeleteFunc(origins(config), func(origin string) bool {
+		return !directoryOrigin(origin)
+	}), fn)
+}
+
+// walkOrigins calls fn for every entry of the origins in turn, listing an
+// entry reachable in several ways only the first time. A complete walk of
+// every origin refreshes the project cache.
+func walkOrigins(config Config, origins []string, fn func(string) error) error {
+	seen := pathSet{}
+	// Directories are cached even if a pin listed them first.
+	cached := pathSet{}
+	var visited []string
+	archived := readArchived()
+	for _, origin := range origins {
+		err := walkOrigin(config, origin, func(p string) error {
+			if directoryOrigin(origin) && cached.add(p) {
+				visited = append(visited, p)
+			}
+			if !seen.add(p) {
+				return nil
+			} else if (len(archived) > 0 || config.showArchived) && archived.has(p) != config.showArchived {
+				return nil
+			}
+
+			return fn(p)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// The cache is best effort and must not fail the walk.
+	if config.onlySources == nil {
+		_ = writeProjectCache(visited)
+	}
+
+	return nil
+}
+
+// defaultBaseDirLimit is the number of entries read from a base dir unless
+// base_dir_limit is set.
+const defaultBaseDirLimit = 10000
+
+// baseDirBatch is the number of entries read from a base dir at a time.
+const baseDirBatch = 1000
+
+// walkBaseDir calls fn for every child directory of baseDir. Entries are
+// read in batches and passed on as they are read, so the picker fills up
+// while a large base dir is still being read. A base dir that fits in one
+// batch is walked in order of name; larger ones in the order the file
+// system returns. Reading stops with a warning once base_dir_limit entries
+// were read, or once reading takes longer than the discovery timeout, e.g.
+// on a hung network mount.
+func walkBaseDir(config Config, baseDir string, fn func(string) error) error {
+	f, err := awaitDiscovery(config, baseDir, func() (*os.File, error) { return os.Open(baseDir) })
+	if err != nil || f == nil {
+		return err
+	}
+	defer f.Close()
+
+	limit := config.BaseDirLimit
+	if limit == 0 {
+		limit = defaultBaseDirLimit
+	}
+
+	read := 0
+	for {
+		entries, err := awaitDiscovery(config, baseDir, func() ([]os.DirEntry, error) { return f.ReadDir(baseDirBatch) })
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		} else if entries == nil {
+			// The read timed out.
+			return nil
+		}
+
+		if read == 0 && len(entries) < baseDirBatch {
+			sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+		}
+
+		for _, entry := range entries {
+			if limit > 0 && read == limit {
+				fmt.Fprintf(stdIO.Stderr, "tsm: listed only the first %d entries of %s; "+
+					"ignore it with ignore_dirs, move projects to a smaller base dir, or raise base_dir_limit\n", limit, baseDir)
+				return nil
+			}
+			read++
+
+			if entry.IsDir() {
+				err = fn(path.Join(baseDir, entry.Name()))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// awaitDiscovery runs f, which reads baseDir, with the discovery timeout. A
+// base dir that times out is skipped with a warning, and the zero value is
+// returned without an error.
+func awaitDiscovery[T any](config Config, baseDir string, f func() (T, error)) (T, error) {
+	ctx, cancel := timeoutContext(config, TimeoutDiscovery)
+	defer cancel()
+
+	value, err := awaitContext(ctx, f)
+	if errors.Is(err, context.DeadlineExceeded) {
+		fmt.Fprintf(stdIO.Stderr, "tsm: gave up reading %s after %s; raise timeouts.discovery if it is just slow\n",
+			baseDir, operationTimeout(config, TimeoutDiscovery))
+		return value, nil
+	}
+
+	return value, err
+}
+
+// pathSet holds project directories by their canonical path, so that a
+// directory is recognized however it was reached.
+type pathSet map[string]bool
+
+// add adds dir to the set and reports whether it was not in it before.
+func (s pathSet) add(dir string) bool {
+	key := canonicalPath(dir)
+	if s[key] {
+		return false
+	}
+
+	s[key] = true
+	return true
+}
+
+// has reports whether dir is in the set.
+func (s pathSet) has(dir string) bool {
+	return s[canonicalPath(dir)]
+}
+
+// canonicalPath returns dir with symlinks resolved. Directories that cannot
+// be resolved, such as remote targets, are only cleaned.
+func canonicalPath(dir string) string {
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		return resolved
+	}
+
+	return path.Clean(dir)
+}
+
+// Project is a directory that can be opened as a session.
+type Project struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Running bool   `json:"running"`
+	// Activity is when the project's session was last used. It is nil if
+	// the session is not running.
+	Activity *time.Time `json:"activity,omitempty"`
+}
+
+func listProjects(config Config) ([]Project, error) {
+	paths, err := listDirectories(config)
+	if err != nil {
+		return nil, err
+	}
+
+	activity := sessionActivity()
+
+	projects := make([]Project, 0, len(paths))
+	for _, p := range paths {
+		project := Project{
+			Name: sessionID(config, p),
+			Path: p,
+		}
+		if t, ok := activity[path.Clean(p)]; ok {
+			project.Running = true
+			project.Activity = &t
+		}
+
+		projects = append(projects, project)
+	}
+
+	return projects, nil
+}
+
+// isIgnored reports whether a discovered directory matches an ignore rule.
+// Rules starting with "/" or "~/" match that directory and everything below
+// it, with an optional trailing "/**" for clarity. Any other rule matches
+// directories whose path ends with it.
+func isIgnored(dir string, config Config) bool {
+	if !config.ShowHidden && strings.HasPrefix(path.Base(dir), ".") {
+		return true
+	}
+
+	return slices.ContainsFunc(config.IgnoreDirs, func(rule string) bool { return ignoreRuleMatches(rule, dir) })
+}
+
+// ignoreRuleMatches reports whether a rule of ignore_dirs matches dir.
+func ignoreRuleMatches(rule, dir string) bool {
+	if strings.HasPrefix(rule, "/") || strings.HasPrefix(rule, "~/") {
+		prefix := path.Clean(strings.TrimSuffix(expandHome(rule), "/**"))
+		// The root is the only cleaned path that ends with a slash.
+		return dir == prefix || strings.HasPrefix(dir, strings.TrimSuffix(prefix, "/")+"/")
+	}
+
+	return strings.HasSuffix(dir, rule)
+}
+
+func sessionExists(id string) bool {
+	return mux.HasSession(id)
+}
+
+// pathOption is the tmux user option recording the project directory a
+// session was created for. Unlike the session name, it survives the session
+// being renamed.
+const pathOption = "@tsm_path"
+
+// templateOption is the tmux user option recording the template a project's
+// session was created from, which may have been chosen at the time.
+const templateOption = "@tsm_template"
+
+// pathFormat expands to a session's project directory, falling back to its
+// working directory for sessions not created by tsm.
+const pathFormat = "#{?" + pathOption + ",#{" + pathOption + "},#{session_path}}"
+
+func sessionPath(id string) (string, error) {
+	return mux.SessionPath(id)
+}
+
+// fieldSep separates the fields of tmux format strings. tmux replaces control
+// characters such as tabs in its output, so a printable sequence is used.
+const fieldSep = "|:|"
+
+func tmuxFormat(fields ...string) string {
+	return strings.Join(fields, fieldSep)
+}
+
+func listSessions() ([]string, error) {
+	out, err := runCommandOutput("tmux", "list-sessions", "-F", "#{session_name}")
+	if err != nil {
+		return nil, err
+	}
+
+	return splitLines(out), nil
+}
+
+// Session is a running tmux session.
+type Session struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+func listSessionDetails() ([]Session, error) {
+	return mux.Sessions()
+}
+
+// sessionActivity returns when the session of each running project directory
+// was last used, as reported by tmux's session_activity.
+func sessionActivity() map[string]time.Time {
+	activity := map[string]time.Time{}
+	if !usingTmux() {
+		return activity
+	}
+
+	out, err := runCommandOutput("tmux", "list-sessions", "-F", tmuxFormat(pathFormat, "#{session_activity}"))
+	if err != nil {
+		return activity
+	}
+
+	for _, line := range splitLines(out) {
+		sessionDir, seconds, _ := strings.Cut(line, fieldSep)
+		unix, err := strconv.ParseInt(seconds, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		t := time.Unix(unix, 0)
+		if t.After(activity[path.Clean(sessionDir)]) {
+			activity[path.Clean(sessionDir)] = t
+		}
+	}
+
+	return activity
+}
+
+// findSessionForPath returns the session created for the project directory
+// dir, whatever it is called now.
+func findSessionForPath(dir string) (string, bool) {
+	return mux.FindSession(dir)
+}
+
+// setSessionOptions sets tmux options scoped to a single session.
+func setSessionOptions(id string, options map[string]string) error {
+	keys := make([]string, 0, len(options))
+	for k := range options {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	for _, k := range keys {
+		err := runCommand(IO{}, "tmux", "set-option", "-t", id, k, options[k])
+		if err != nil {
+			return fmt.Errorf("tsm: setting session option %q: %w", k, err)
+		}
+	}
+
+	return nil
+}
+
+func killSession(id string) error {
+	return mux.KillSession(id)
+}
+
+// createSession creates a detached session rooted in targetDir. If shell is
+// not empty, it is run in place of the default shell in the session's first
+// window and in any window or pane created afterwards. The variables in env are
+// set in the session's environment. args are passed to new-session as well,
+// and conf, if not empty, is a tmux config file sourced into the session.
+func createSession(id, targetDir, shell string, env map[string]string, args []string, conf string) error {
+	err := checkSessionName(id)
+	if err != nil {
+		return err
+	}
+
+	command := []string{"tmux", "new-session", "-d", "-s", id, "-c", targetDir}
+	command = append(command, envArgs(env)...)
+	command = append(command, args...)
+
+	if shell != "" {
+		command = append(command, shell)
+	}
+
+	// Commands chained to new-session apply to the new session by default,
+	// which scopes the session options set by the file.
+	if conf != "" {
+		command = append(command, ";", "source-file", conf)
+	}
+
+	var stderr bytes.Buffer
+	err = runCommand(IO{Stderr: &stderr}, command...)
+	if msg := strings.TrimSpace(stderr.String()); err != nil && strings.HasPrefix(msg, "duplicate session") {
+		return fmt.Errorf("tsm: session %q was created concurrently: %w", id, errDuplicateSession)
+	} else if err != nil && msg != "" {
+		return fmt.Errorf("tsm: %s: %w", msg, err)
+	} else if err != nil {
+		return err
+	}
+
+	err = runCommand(IO{}, "tmux", "set-option", "-t", id, pathOption, targetDir)
+	if err != nil {
+		return err
+	}
+	recordEvent(EventCreated, id, targetDir)
+
+	if shell == "" {
+		return nil
+	}
+
+	return runCommand(IO{}, "tmux", "set-option", "-t", id, "default-command", shell)
+}
+
+// newSessionArgs returns the extra new-session arguments for a project
+// directory: the configured ones followed by the project's own, so that the
+// project's take precedence.
+func newSessionArgs(config Config, targetDir string) []string {
+	args := slices.Clone(config.NewSessionArgs)
+	if p, ok := findProject(config, targetDir); ok {
+		args = append(args, p.NewSessionArgs...)
+	}
+
+	return args
+}
+
+// envArgs returns the new-session arguments setting the variables in env.
+func envArgs(env map[string]string) []string {
+	var args []string
+	for _, v := range envList(env) {
+		args = append(args, "-e", v)
+	}
+
+	return args
+}
+
+// envList returns the variables in env as KEY=value, sorted by key.
+func envList(env map[string]string) []string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	list := make([]string, 0, len(keys))
+	for _, k := range keys {
+		list = append(list, k+"="+env[k])
+	}
+
+	return list
+}
+
+func switchToSession(config Config, id string) error {
+	// History and fetching are best effort and must not prevent switching.
+	sessionDir, err := sessionPath(id)
+	if err == nil {
+		_ = recordSwitch(id, sessionDir)
+		_ = fetchProject(config, sessionDir)
+		_ = startPrefetch(config)
+	}
+
+	return mux.Attach(config, id)
+}
+
+// insideTmux reports whether tsm runs within a client of a live tmux server,
+// in which case sessions are switched to rather than attached. $TMUX alone is
+// not trusted since it outlives the server it points to, and the server may
+// have no client to switch, e.g. after detaching.
+var insideTmux = sync.OnceValue(func() bool {
+	if os.Getenv("TMUX") == "" {
+		return false
+	}
+
+	client, err := runCommandOutput("tmux", "display-message", "-p", "#{client_tty}")
+	return err == nil && strings.TrimSpace(client) != ""
+})
+
+// tmuxSocket returns the socket of the server named by $TMUX, if set.
+// Otherwise, if $TMUX_TMPDIR is set, it returns the default server's socket
+// there, since a terminal opened by spawn_terminal may not inherit the
+// variable.
+func tmuxSocket() string {
+	socket, _, _ := strings.Cut(os.Getenv("TMUX"), ",")
+	if socket == "" && os.Getenv("TMUX_TMPDIR") != "" {
+		return defaultTmuxSocket()
+	}
+
+	return socket
+}
+
+// environWithoutTmux returns the environment with $TMUX removed, which tmux
+// requires to attach from within a pane.
+func environWithoutTmux() []string {
+	return slices.DeleteFunc(os.Environ(), func(v string) bool {
+		return strings.HasPrefix(v, "TMUX=")
+	})
+}
+
+// attachCommand builds the command attaching to a session. Locked sessions
+// are attached read-only. The command runs without $TMUX, so the server it
+// named is passed explicitly.
+func attachCommand(id string) []string {
+	command := []string{"tmux"}
+	if socket := tmuxSocket(); socket != "" {
+		command = append(command, "-S", socket)
+	}
+
+	if sessionLocked(id) {
+		return append(command, "attach", "-r", "-t", id)
+	}
+
+	return append(command, "attach", "-t", id)
+}
+
+// spawnTerminal opens a new terminal window attached to the session.
+func spawnTerminal(config Config, id string) error {
+	return spawnTerminalCommand(config, attachCommand(id))
+}
+
+// spawnTerminalCommand opens a new terminal window running command. The
+// terminal is started in its own process group and is not waited on so that
+// tsm can exit while the terminal stays open.
+func spawnTerminalCommand(config Config, command []string) error {
+	terminal := strings.Fields(config.Terminal)
+	if len(terminal) == 0 {
+		terminal = strings.Fields(os.Getenv("TERMINAL"))
+	}
+	if len(terminal) == 0 {
+		return errors.New("tsm: no terminal configured; set terminal in the config or $TERMINAL")
+	}
+
+	cmd := newCommand(IO{}, append(terminal, command...)...)
+	cmd.Env = environWithoutTmux()
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	return cmd.Process.Release()
+}
+
+// attachToSession attaches to a session in the current terminal. Errors
+// printed by tmux are returned rather than printed.
+func attachToSession(id string) error {
+	var stderr bytes.Buffer
+	cmd := newCommand(IO{Stdin: stdIO.Stdin, Stdout: stdIO.Stdout, Stderr: &stderr}, attachCommand(id)...)
+	cmd.Env = environWithoutTmux()
+
+	err := cmd.Run()
+	if msg := strings.TrimSpace(stderr.String()); err != nil && msg != "" {
+		return fmt.Errorf("tsm: %s: %w", msg, err)
+	}
+
+	return err
+}
+
+// recoverAttach handles an attach that failed because the tmux server exited
+// or the session was killed elsewhere. Rather than failing with tmux's error,
+// tsm offers to recreate the session or to pick another project.
+func recoverAttach(config Config, id, sessionDir string, err error) error {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || sessionExists(id) {
+		return err
+	}
+
+	if _, serverErr := runCommandOutput("tmux", "list-sessions"); serverErr != nil {
+		fmt.Fprintf(stdIO.Stderr, "tsm: the tmux server exited while attached to %q\n", id)
+	} else {
+		fmt.Fprintf(stdIO.Stderr, "tsm: session %q was killed while attached\n", id)
+	}
+
+	options := "[p]ick another project, [q]uit: "
+	if sessionDir != "" {
+		options = "[r]ecreate it, " + options
+	}
+
+	answer, err := prompt(options)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(answer) {
+	case "r", "recreate":
+		if sessionDir == "" {
+			return nil
+		}
+		return switchToProject(config, sessionDir)
+	case "p", "pick":
+		configPath, err := getConfigPath()
+		if err != nil {
+			return err
+		}
+		return handleSessionSwitch(configPath, config)
+	}
+
+	return nil
+}
+
+// switchSession switches the current client to the session. The client is
+// made read-only while it displays a locked session.
+func switchSession(id string) error {
+	err := runCommand(stdIO, "tmux", "switch-client", "-t", id)
+	if err != nil {
+		return err
+	}
+
+	readOnly := "!read-only"
+	if sessionLocked(id) {
+		readOnly = "read-only"
+	}
+
+	return runCommand(IO{}, "tmux", "refresh-client", "-f", readOnly)
+}
+
+func runCommand(inOut IO, command ...string) error {
+	return tmuxFailure(command, newCommand(inOut, command...).Run())
+}
+
+func runCommandOutput(command ...string) (string, error) {
+	return runCommandOutputContext(runContext, command...)
+}
+
+// runCommandOutputContext is runCommandOutput for commands that are killed
+// once ctx is done.
+func runCommandOutputContext(ctx context.Context, command ...string) (string, error) {
+	out := bytes.NewBuffer([]byte{})
+	err := newCommandContext(ctx, IO{Stdout: out}, command...).Run()
+
+	return out.String(), tmuxFailure(command, err)
+}
+
+func splitLines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+func newCommand(inOut IO, command ...string) *exec.Cmd {
+	return newCommandContext(runContext, inOut, command...)
+}
+
+// newCommandContext is newCommand for commands that are killed once ctx is
+// done.
+func newCommandContext(ctx context.Context, inOut IO, command ...string) *exec.Cmd {
+	if len(command) == 0 {
+		panic("tsm: empty command provided")
+	}
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+
+	cmd.Stdin = inOut.Stdin
+	cmd.Stdout = inOut.Stdout
+	cmd.Stderr = inOut.Stderr
+
+	return cmd
 }
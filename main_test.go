@@ -0,0 +1,221 @@
+package main
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestIgnoreRuleMatches(t *testing.T) {
+	t.Setenv("HOME", "/home/me")
+
+	tests := []struct {
+		rule string
+		dir  string
+		want bool
+	}{
+		{rule: "node_modules", dir: "/home/me/code/node_modules", want: true},
+		{rule: "node_modules", dir: "/home/me/code/api", want: false},
+		{rule: "modules", dir: "/home/me/code/node_modules", want: true},
+		{rule: "code/api", dir: "/home/me/code/api", want: true},
+		{rule: "/home/me/work/archive", dir: "/home/me/work/archive", want: true},
+		{rule: "/home/me/work/archive", dir: "/home/me/work/archive/old", want: true},
+		{rule: "/home/me/work/archive", dir: "/home/me/work/archived", want: false},
+		{rule: "/home/me/work/archive", dir: "/home/me/work", want: false},
+		{rule: "/home/me/work/archive/", dir: "/home/me/work/archive/old", want: true},
+		{rule: "/home/me/work/archive/**", dir: "/home/me/work/archive", want: true},
+		{rule: "/home/me/work/archive/**", dir: "/home/me/work/archive/old/api", want: true},
+		{rule: "/home/me/work/archive/**", dir: "/home/me/work/archived", want: false},
+		{rule: "~/work/archive/**", dir: "/home/me/work/archive/old", want: true},
+		{rule: "~/work/archive", dir: "/home/me/work/archive", want: true},
+		{rule: "~/work/archive/**", dir: "/home/you/work/archive/old", want: false},
+		{rule: "/", dir: "/home/me/code/api", want: true},
+	}
+
+	for _, tt := range tests {
+		got := ignoreRuleMatches(tt.rule, tt.dir)
+		if got != tt.want {
+			t.Errorf("ignoreRuleMatches(%q, %q) = %v, want %v", tt.rule, tt.dir, got, tt.want)
+		}
+	}
+}
+
+// TestDiscoveryPrecedence checks which directories are listed when base
+// dirs, ignore rules, and registered projects disagree: ignore rules take
+// precedence over base dirs, and registered projects over ignore rules.
+func TestDiscoveryPrecedence(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("HOME", root)
+	t.Setenv("XDG_CONFIG_HOME", path.Join(root, ".config"))
+	t.Setenv("XDG_CACHE_HOME", path.Join(root, ".cache"))
+	t.Setenv("XDG_STATE_HOME", path.Join(root, ".local/state"))
+
+	for _, dir := range []string{
+		"code/api",
+		"code/web",
+		"code/node_modules",
+		"code/.dotfiles",
+		"work/archive/old",
+		"work/billing",
+	} {
+		err := os.MkdirAll(path.Join(root, dir), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		config Config
+		want   []string
+	}{
+		{
+			name:   "base dirs list their children",
+			config: Config{BaseDirs: []string{"~/code", "~/work"}},
+			want:   []string{"code/api", "code/node_modules", "code/web", "work/archive", "work/billing"},
+		},
+		{
+			name:   "hidden directories are shown when asked",
+			config: Config{BaseDirs: []string{"~/code"}, ShowHidden: true},
+			want:   []string{"code/.dotfiles", "code/api", "code/node_modules", "code/web"},
+		},
+		{
+			name:   "ignore rules take precedence over base dirs",
+			config: Config{BaseDirs: []string{"~/code", "~/work"}, IgnoreDirs: []string{"node_modules", "~/work/archive/**"}},
+			want:   []string{"code/api", "code/web", "work/billing"},
+		},
+		{
+			name:   "a prefix rule covers a whole base dir",
+			config: Config{BaseDirs: []string{"~/code", "~/work/archive"}, IgnoreDirs: []string{"~/work/archive/**"}},
+			want:   []string{"code/api", "code/node_modules", "code/web"},
+		},
+		{
+			name: "registered projects are never ignored",
+			config: Config{
+				BaseDirs:   []string{"~/code"},
+				IgnoreDirs: []string{"node_modules", "~/work/archive/**", "/"},
+				Projects:   []ProjectConfig{{Path: "~/work/archive/old"}, {Path: "~/code/node_modules"}},
+			},
+			want: []string{"code/node_modules", "work/archive/old"},
+		},
+		{
+			name: "registered projects are listed before base dirs and only once",
+			config: Config{
+				BaseDirs: []string{"~/code"},
+				Projects: []ProjectConfig{{Path: "~/code/web"}, {Path: "~/work/billing/"}},
+			},
+			want: []string{"code/api", "code/node_modules", "code/web", "work/billing"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dirs, err := listDirectories(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := make([]string, 0, len(tt.want))
+			for _, dir := range tt.want {
+				want = append(want, path.Join(root, dir))
+			}
+			slices.Sort(dirs)
+			if !slices.Equal(dirs, want) {
+				t.Errorf("listDirectories() = %q, want %q", dirs, want)
+			}
+		})
+	}
+
+	// Registered projects come first, in the order they were registered.
+	dirs, err := listDirectories(Config{
+		BaseDirs: []string{"~/code"},
+		Projects: []ProjectConfig{{Path: "~/work/billing"}, {Path: "~/code/web"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{path.Join(root, "work/billing"), path.Join(root, "code/web"), path.Join(root, "code/api")}
+	if len(dirs) < len(want) || !slices.Equal(dirs[:len(want)], want) {
+		t.Errorf("listDirectories() = %q, want it to start with %q", dirs, want)
+	}
+}
+
+func TestMergeConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		dst  map[string]any
+		src  map[string]any
+		want map[string]any
+	}{
+		{
+			name: "lists are appended",
+			dst:  map[string]any{"base_dirs": []any{"~/code"}},
+			src:  map[string]any{"base_dirs": []any{"~/work"}},
+			want: map[string]any{"base_dirs": []any{"~/code", "~/work"}},
+		},
+		{
+			name: "values are overridden",
+			dst:  map[string]any{"terminal": "kitty", "show_hidden": false},
+			src:  map[string]any{"terminal": "alacritty"},
+			want: map[string]any{"terminal": "alacritty", "show_hidden": false},
+		},
+		{
+			name: "objects are merged by key",
+			dst:  map[string]any{"templates": map[string]any{"go": "a", "web": "b"}},
+			src:  map[string]any{"templates": map[string]any{"web": "c", "rust": "d"}},
+			want: map[string]any{"templates": map[string]any{"go": "a", "web": "c", "rust": "d"}},
+		},
+		{
+			name: "nested lists are appended",
+			dst: map[string]any{"templates": map[string]any{"go": map[string]any{
+				"windows": []any{map[string]any{"name": "editor"}},
+				"shell":   "bash",
+			}}},
+			src: map[string]any{"templates": map[string]any{"go": map[string]any{
+				"windows": []any{map[string]any{"name": "tests"}},
+				"shell":   "zsh",
+			}}},
+			want: map[string]any{"templates": map[string]any{"go": map[string]any{
+				"windows": []any{map[string]any{"name": "editor"}, map[string]any{"name": "tests"}},
+				"shell":   "zsh",
+			}}},
+		},
+		{
+			name: "values of another type replace",
+			dst:  map[string]any{"projects": []any{"~/code/api"}, "timeouts": map[string]any{"git": "5s"}},
+			src:  map[string]any{"projects": "~/code/web", "timeouts": nil},
+			want: map[string]any{"projects": "~/code/web", "timeouts": nil},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mergeConfig(tt.dst, tt.src)
+			if !reflect.DeepEqual(tt.dst, tt.want) {
+				t.Errorf("mergeConfig() = %v, want %v", tt.dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestOverrideConfig(t *testing.T) {
+	dst := map[string]any{
+		"base_dirs": []any{"~/code"},
+		"templates": map[string]any{"go": map[string]any{"windows": []any{"editor"}, "shell": "bash"}},
+	}
+	src := map[string]any{
+		"base_dirs": []any{"~/work"},
+		"templates": map[string]any{"go": map[string]any{"windows": []any{"tests"}}},
+	}
+	want := map[string]any{
+		"base_dirs": []any{"~/work"},
+		"templates": map[string]any{"go": map[string]any{"windows": []any{"tests"}, "shell": "bash"}},
+	}
+
+	overrideConfig(dst, src)
+	if !reflect.DeepEqual(dst, want) {
+		t.Errorf("overrideConfig() = %v, want %v", dst, want)
+	}
+}
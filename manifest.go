@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// defaultManifest is the manifest read by up when no file is given.
+const defaultManifest = "tsm.json"
+
+// Manifest declares the sessions that should be running. Workspaces in the
+// config are manifests too.
+type Manifest struct {
+	Sessions []ManifestSession `json:"sessions"`
+}
+
+// ManifestSession is a session declared in a manifest.
+type ManifestSession struct {
+	// Project is a project name or a directory. Relative directories are
+	// resolved against the manifest's directory.
+	Project string `json:"project"`
+	// Name overrides the session name derived from the project.
+	Name string `json:"name,omitempty"`
+	// Template overrides the template the project would otherwise use.
+	Template string `json:"template,omitempty"`
+	// Env sets environment variables in the session on top of the
+	// template's.
+	Env map[string]string `json:"env,omitempty"`
+	// DependsOn lists sessions, by name or project, that must be up and
+	// ready before this session is created.
+	DependsOn []string `json:"depends_on,omitempty"`
+	// Ready is the condition under which the session counts as ready for
+	// the sessions that depend on it.
+	Ready *WaitFor `json:"ready,omitempty"`
+}
+
+// key identifies the session for DependsOn.
+func (s ManifestSession) key() string {
+	if s.Name != "" {
+		return s.Name
+	}
+
+	return s.Project
+}
+
+func readManifest(manifestPath string) (Manifest, error) {
+	f, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	var manifest Manifest
+	err = json.Unmarshal(f, &manifest)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("tsm: invalid manifest %s: %w", manifestPath, err)
+	}
+
+	return manifest, nil
+}
+
+// handleUp converges the running sessions to those declared in a manifest.
+// Missing sessions are created and, with --prune, sessions not in the
+// manifest are killed. Existing sessions are left as they are.
+func handleUp(config Config, args []string) error {
+	flags := flag.NewFlagSet("up", flag.ExitOnError)
+	manifestPath := flags.String("f", defaultManifest, "")
+	prune := flags.Bool("prune", false, "")
+	flags.Parse(args)
+
+	manifest, err := readManifest(*manifestPath)
+	if err != nil {
+		return err
+	}
+
+	manifestDir, err := filepath.Abs(filepath.Dir(*manifestPath))
+	if err != nil {
+		return err
+	}
+
+	return upManifest(config, manifest, manifestDir, *prune)
+}
+
+// upManifest creates the sessions of a manifest in dependency order, waiting
+// for each to be ready before creating the sessions that depend on it.
+func upManifest(config Config, manifest Manifest, manifestDir string, prune bool) error {
+	sessions, err := orderSessions(manifest.Sessions)
+	if err != nil {
+		return err
+	}
+
+	var declared []string
+	for _, s := range sessions {
+		id, targetDir, err := upSession(config, manifestDir, s)
+		if err != nil {
+			return err
+		} else if id == "" {
+			return errors.New("tsm: up cancelled")
+		}
+
+		declared = append(declared, id)
+
+		if s.Ready != nil {
+			err = s.Ready.wait(targetDir, fmt.Sprintf("session %q", id))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if !prune {
+		return nil
+	}
+
+	running, err := listSessions()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range running {
+		if slices.Contains(declared, id) {
+			continue
+		}
+
+		if sessionLocked(id) {
+			fmt.Fprintf(stdIO.Stdout, "kept %s (locked)\n", id)
+			continue
+		}
+
+		sessionDir, _ := sessionPath(id)
+		err = trashSession(config, id)
+		if err != nil {
+			return err
+		}
+		recordEvent(EventPruned, id, sessionDir)
+		fmt.Fprintf(stdIO.Stdout, "pruned %s\n", id)
+	}
+
+	return nil
+}
+
+// upSession ensures the session declared by s exists and returns its name and
+// directory.
+func upSession(config Config, manifestDir string, s ManifestSession) (string, string, error) {
+	if s.Project == "" {
+		return "", "", errors.New("tsm: manifest session without a project")
+	}
+
+	project := expandHome(s.Project)
+	if strings.ContainsRune(project, '/') && !filepath.IsAbs(project) {
+		project = filepath.Join(manifestDir, project)
+	}
+
+	targetDir, err := resolveProject(config, project)
+	if err != nil {
+		return "", "", err
+	}
+
+	if id, ok := findSessionForPath(targetDir); ok {
+		return id, targetDir, nil
+	}
+
+	id := sessionID(config, targetDir)
+	if s.Name != "" {
+		id = cleanID(config, s.Name)
+	}
+
+	if sessionExists(id) {
+		id, err = resolveConflict(config, id, targetDir)
+		if err != nil || id == "" || sessionExists(id) {
+			return id, targetDir, err
+		}
+	}
+
+	templateName := s.Template
+	if templateName == "" {
+		templateName = projectTemplate(config, targetDir)
+	}
+
+	var t Template
+	if templateName != "" {
+		t, err = lookupTemplate(config, templateName)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	if len(s.Env) > 0 {
+		env := map[string]string{}
+		for k, v := range t.Env {
+			env[k] = v
+		}
+		for k, v := range s.Env {
+			env[k] = v
+		}
+		t.Env = env
+	}
+
+	err = createTemplateSession(config, id, targetDir, t)
+	if err != nil {
+		return "", "", err
+	}
+	fmt.Fprintf(stdIO.Stdout, "created %s\n", id)
+
+	return id, targetDir, nil
+}
+
+// orderSessions sorts sessions so that every session comes after the
+// sessions it depends on. Sessions are otherwise kept in declaration order.
+func orderSessions(sessions []ManifestSession) ([]ManifestSession, error) {
+	byKey := map[string]ManifestSession{}
+	for _, s := range sessions {
+		byKey[s.key()] = s
+	}
+
+	var ordered []ManifestSession
+	done := map[string]bool{}
+	var visit func(s ManifestSession, path []string) error
+	visit = func(s ManifestSession, path []string) error {
+		if done[s.key()] {
+			return nil
+		} else if slices.Contains(path, s.key()) {
+			return fmt.Errorf("tsm: dependency cycle: %s", strings.Join(append(path, s.key()), " -> "))
+		}
+		path = append(path, s.key())
+
+		for _, dep := range s.DependsOn {
+			d, ok := byKey[dep]
+			if !ok {
+				return fmt.Errorf("tsm: session %q depends on unknown session %q", s.key(), dep)
+			}
+
+			err := visit(d, path)
+			if err != nil {
+				return err
+			}
+		}
+
+		done[s.key()] = true
+		ordered = append(ordered, s)
+		return nil
+	}
+
+	for _, s := range sessions {
+		err := visit(s, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ordered, nil
+}
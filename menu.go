@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// menuSize is the number of projects in the menu, one per digit key.
+const menuSize = 9
+
+// handleMenu presents pinned and recently used projects in a tmux menu, a
+// lightweight alternative to the picker that needs no external program.
+func handleMenu(config Config) error {
+	if !insideTmux() {
+		return errors.New("tsm: the menu can only be shown inside tmux")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	command := []string{"tmux", "display-menu", "-T", "tsm"}
+	for i, dir := range menuProjects() {
+		key := strconv.Itoa(i + 1)
+		run := "run-shell -b " + tmuxQuote(shellJoin([]string{exe, "switch", dir}))
+		command = append(command, sessionID(config, dir), key, run)
+	}
+
+	if len(command) == 4 {
+		return errors.New("tsm: no pinned or recent projects")
+	}
+
+	return runCommand(stdIO, command...)
+}
+
+// menuProjects returns the pinned project directories followed by the most
+// recently used ones.
+func menuProjects() []string {
+	var dirs []string
+	add := func(dir string) {
+		if len(dirs) < menuSize && !slices.Contains(dirs, dir) && validateTarget(dir) == nil {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	for _, p := range readPins() {
+		if _, ok := parseRemoteSession(p); !ok {
+			add(p)
+		}
+	}
+
+	state, err := loadState()
+	if err != nil {
+		return dirs
+	}
+
+	for i := len(state.History) - 1; i >= 0 && len(dirs) < menuSize; i-- {
+		add(state.History[i].Path)
+	}
+
+	return dirs
+}
+
+// tmuxQuote quotes s as a single argument of a tmux command. Formats are
+// expanded in menu commands, so "#" is escaped too.
+func tmuxQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "#", "##")
+	return `"` + r.Replace(s) + `"`
+}
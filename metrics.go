@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// serverMetrics counts what the server does for the metrics endpoint.
+type serverMetrics struct {
+	sessionsCreated atomic.Int64
+	switches        atomic.Int64
+	kills           atomic.Int64
+
+	// Scans are the project discoveries of ListProjects.
+	scans         atomic.Int64
+	scanNanos     atomic.Int64
+	lastProjects  atomic.Int64
+	lastScanNanos atomic.Int64
+}
+
+// observeScan records a project discovery that took d and found n projects.
+func (m *serverMetrics) observeScan(d time.Duration, n int) {
+	m.scans.Add(1)
+	m.scanNanos.Add(int64(d))
+	m.lastScanNanos.Store(int64(d))
+	m.lastProjects.Store(int64(n))
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format. Gauges of the
+// tmux server and the cache are read on every scrape.
+func (m *serverMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	writeMetric(w, "tsm_sessions_created_total", "counter", "Sessions created through the control API.", m.sessionsCreated.Load())
+	writeMetric(w, "tsm_switches_total", "counter", "Switches through the control API.", m.switches.Load())
+	writeMetric(w, "tsm_kills_total", "counter", "Sessions killed through the control API.", m.kills.Load())
+
+	fmt.Fprintln(w, "# HELP tsm_scan_duration_seconds Time spent discovering projects.")
+	fmt.Fprintln(w, "# TYPE tsm_scan_duration_seconds summary")
+	fmt.Fprintf(w, "tsm_scan_duration_seconds_sum %g\n", time.Duration(m.scanNanos.Load()).Seconds())
+	fmt.Fprintf(w, "tsm_scan_duration_seconds_count %d\n", m.scans.Load())
+	writeMetric(w, "tsm_last_scan_duration_seconds", "gauge", "Duration of the last project discovery.", time.Duration(m.lastScanNanos.Load()).Seconds())
+	writeMetric(w, "tsm_projects", "gauge", "Projects found by the last project discovery.", m.lastProjects.Load())
+
+	// Without a tmux server, no session is running.
+	sessions, _ := listSessionDetails()
+	writeMetric(w, "tsm_sessions", "gauge", "Running tmux sessions.", len(sessions))
+	writeMetric(w, "tsm_cache_bytes", "gauge", "Size of the cache directory.", cacheSize())
+}
+
+func writeMetric(w io.Writer, name, kind, help string, value any) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+}
+
+// cacheSize returns the total size of the files in tsm's cache directory.
+func cacheSize() int64 {
+	dir, err := getCachePath("")
+	if err != nil {
+		return 0
+	}
+
+	var size int64
+	_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return size
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+// mirrorOption is the global tmux option listing the terminals of mirror
+// clients, separated by spaces.
+const mirrorOption = "@tsm_mirrors"
+
+// handleMirror attaches the current terminal as a read-only mirror of a
+// session, by default the most recently attached one. The mirror follows
+// every switch made through tsm, e.g. on a second monitor or a projector. It
+// does not resize the session, so the main client keeps its size.
+func handleMirror(args []string) error {
+	if insideTmux() {
+		return errors.New("tsm: run mirror in a terminal outside tmux")
+	}
+
+	var id string
+	if len(args) > 0 {
+		id = args[0]
+	} else {
+		var err error
+		id, err = lastAttachedSession()
+		if err != nil {
+			return err
+		}
+	}
+
+	if !sessionExists(id) {
+		return fmt.Errorf("tsm: session %q does not exist", id)
+	}
+
+	var out strings.Builder
+	err := newCommand(IO{Stdin: os.Stdin, Stdout: &out}, "tty").Run()
+	if err != nil {
+		return errors.New("tsm: mirror requires a terminal")
+	}
+	tty := strings.TrimSpace(out.String())
+
+	err = setMirrors(func(ttys []string) []string { return append(ttys, tty) })
+	if err != nil {
+		return err
+	}
+	// Signals that end the terminal also end the client, and tsm stays to
+	// forget the mirror once it is gone.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	defer setMirrors(func(ttys []string) []string {
+		return slices.DeleteFunc(ttys, func(t string) bool { return t == tty })
+	})
+
+	command := []string{"tmux"}
+	if socket := tmuxSocket(); socket != "" {
+		command = append(command, "-S", socket)
+	}
+
+	cmd := newCommand(stdIO, append(command, "attach", "-f", "read-only,ignore-size", "-t", id)...)
+	cmd.Env = environWithoutTmux()
+	return cmd.Run()
+}
+
+// lastAttachedSession returns the session that a client attached to most
+// recently.
+func lastAttachedSession() (string, error) {
+	out, err := runCommandOutput("tmux", "list-sessions", "-F", tmuxFormat("#{session_last_attached}", "#{session_name}"))
+	if err != nil {
+		return "", errors.New("tsm: no session to mirror")
+	}
+
+	var id, latest string
+	for _, line := range splitLines(out) {
+		attached, name, _ := strings.Cut(line, fieldSep)
+		if len(attached) > len(latest) || (len(attached) == len(latest) && attached > latest) {
+			id, latest = name, attached
+		}
+	}
+
+	if id == "" {
+		return "", errors.New("tsm: no session to mirror")
+	}
+
+	return id, nil
+}
+
+// setMirrors updates the terminals of the mirror clients.
+func setMirrors(update func([]string) []string) error {
+	ttys := update(strings.Fields(tmuxGlobalOption(mirrorOption)))
+	if len(ttys) == 0 {
+		return runCommand(IO{}, "tmux", "set-option", "-gu", mirrorOption)
+	}
+
+	return runCommand(IO{}, "tmux", "set-option", "-g", mirrorOption, strings.Join(ttys, " "))
+}
+
+// followMirrors switches every attached mirror client to the session.
+// Mirrors are best effort and never fail a switch.
+func followMirrors(id string) {
+	ttys := strings.Fields(tmuxGlobalOption(mirrorOption))
+	if len(ttys) == 0 {
+		return
+	}
+
+	// A terminal is only a mirror while its client is read-only, since
+	// another client may reuse the terminal of a mirror that is gone.
+	out, err := runCommandOutput("tmux", "list-clients", "-F", tmuxFormat("#{client_tty}", "#{client_readonly}"))
+	if err != nil {
+		return
+	}
+
+	var clients []string
+	for _, line := range splitLines(out) {
+		if tty, readOnly, _ := strings.Cut(line, fieldSep); readOnly == "1" {
+			clients = append(clients, tty)
+		}
+	}
+
+	for _, tty := range ttys {
+		if slices.Contains(clients, tty) {
+			_ = runCommand(IO{}, "tmux", "switch-client", "-c", tty, "-t", id)
+		}
+	}
+}
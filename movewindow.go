@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// handleMoveWindow moves the current window to the session of another
+// project, picked with the picker unless given, and follows it there. It is
+// meant for work started in the wrong project's session.
+func handleMoveWindow(config Config, args []string) error {
+	flags := flag.NewFlagSet("move-window", flag.ExitOnError)
+	stay := flags.Bool("stay", false, "")
+	flags.Parse(args)
+
+	var targetDir string
+	if flags.NArg() > 0 {
+		var err error
+		targetDir, err = resolveProject(config, strings.Join(flags.Args(), " "))
+		if err != nil {
+			return err
+		}
+	} else {
+		for {
+			key, target, err := getTargetDir(config)
+			if err != nil {
+				return err
+			} else if key == pickerKeyCreate {
+				targetDir, err = createProject(config, "", target)
+				if err != nil || targetDir == "" {
+					return err
+				}
+				break
+			} else if target == "" {
+				return nil
+			}
+
+			if key == "" || key == pickerKeyMove {
+				targetDir = target
+				break
+			} else if key == pickerKeyEdit {
+				continue
+			}
+
+			// The picker is reopened after any other action.
+			err = handlePickerAction(config, key, target)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return moveWindow(config, targetDir, !*stay)
+}
+
+// moveWindow moves the current window to the session of a project directory,
+// creating the session if necessary, and optionally switches to the window.
+func moveWindow(config Config, targetDir string, follow bool) error {
+	if os.Getenv("TMUX") == "" {
+		return errors.New("tsm: move-window must be run inside tmux")
+	} else if config.Mode == ModeWindows {
+		return errors.New("tsm: move-window requires a session per project")
+	} else if _, ok := parseRemoteSession(targetDir); ok {
+		return errors.New("tsm: cannot move a window to a remote session")
+	}
+
+	err := validateTarget(targetDir)
+	if err != nil {
+		return err
+	}
+
+	// The pane tsm runs in is the one to move, while a popup has none and
+	// moves the window of its client.
+	command := []string{"tmux", "display-message", "-p"}
+	if pane := os.Getenv("TMUX_PANE"); pane != "" {
+		command = append(command, "-t", pane)
+	}
+	out, err := runCommandOutput(append(command, tmuxFormat("#{window_id}", "#{session_name}"))...)
+	if err != nil {
+		return err
+	}
+	window, source, _ := strings.Cut(strings.TrimSpace(out), fieldSep)
+
+	id, err := ensureSession(config, targetDir)
+	if err != nil || id == "" {
+		return err
+	}
+
+	if id == source {
+		return fmt.Errorf("tsm: the window is already in session %q", id)
+	}
+
+	// The window keeps its ID and is given the next free index.
+	err = runCommand(IO{}, "tmux", "move-window", "-s", window, "-t", id+":")
+	if err != nil {
+		return err
+	}
+
+	if !follow {
+		return nil
+	}
+
+	err = runCommand(IO{}, "tmux", "select-window", "-t", window)
+	if err != nil {
+		return err
+	}
+
+	return switchToSession(config, id)
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Terminal multiplexers tsm can manage sessions with.
+const (
+	// MultiplexerTmux supports every feature of tsm.
+	MultiplexerTmux = "tmux"
+	// MultiplexerZellij is experimental and supports discovering, creating,
+	// switching to, and killing sessions.
+	MultiplexerZellij = "zellij"
+)
+
+// multiplexer manages the sessions of a terminal multiplexer. The picker,
+// discovery, history, and the rest of the state go through it rather than
+// tmux, so that they work with another multiplexer. Features that build on
+// tmux specifics call tmux directly and are refused for other multiplexers.
+type multiplexer interface {
+	// Sessions returns the running sessions along with their project
+	// directories.
+	Sessions() ([]Session, error)
+	HasSession(id string) bool
+	// SessionPath returns the project directory of a session.
+	SessionPath(id string) (string, error)
+	// FindSession returns the session created for a project directory,
+	// whatever it is called now.
+	FindSession(dir string) (string, bool)
+	// CreateSession creates a detached session for a project directory from
+	// a resolved template.
+	CreateSession(config Config, id, dir string, t Template) error
+	// Attach switches the current client to a session, or attaches to it in
+	// the current terminal or one spawned for it.
+	Attach(config Config, id string) error
+	KillSession(id string) error
+}
+
+// mux is the multiplexer selected by the multiplexer setting.
+var mux multiplexer = tmuxMultiplexer{}
+
+// selectMultiplexer sets mux from the config.
+func selectMultiplexer(config Config) error {
+	switch config.Multiplexer {
+	case "", MultiplexerTmux:
+		mux = tmuxMultiplexer{}
+	case MultiplexerZellij:
+		if config.Mode == ModeWindows {
+			return fmt.Errorf("tsm: the %s multiplexer requires a session per project", MultiplexerZellij)
+		}
+		mux = zellijMultiplexer{}
+	default:
+		return fmt.Errorf("tsm: unknown multiplexer %q", config.Multiplexer)
+	}
+
+	return nil
+}
+
+// usingTmux reports whether sessions are managed with tmux.
+func usingTmux() bool {
+	_, ok := mux.(tmuxMultiplexer)
+	return ok
+}
+
+// tmuxOnlyCommands are the subcommands that build on tmux specifics, such as
+// user options, clients, and the layout of windows and panes.
+var tmuxOnlyCommands = []string{
+	"tmp", "preview", "ui", "undo", "plugin-init", "bootstrap", "mirror", "copy-env",
+	"lock", "unlock", "status", "resume", "auto-switch", "prefetch", "provision", "mv",
+	"move-window", "exec", "run-template", "up", "workspace", "last", "time", "save",
+	"restore", "export", "import", "serve", "anchors", "menu", "edit", "find",
+}
+
+// checkMultiplexerCommand refuses subcommands the selected multiplexer does
+// not support.
+func checkMultiplexerCommand(command string) error {
+	if usingTmux() || !slices.Contains(tmuxOnlyCommands, command) {
+		return nil
+	}
+
+	return fmt.Errorf("tsm: %s requires the %s multiplexer", command, MultiplexerTmux)
+}
+
+// tmuxMultiplexer manages tmux sessions, recording the project directory of
+// each in pathOption.
+type tmuxMultiplexer struct{}
+
+func (tmuxMultiplexer) Sessions() ([]Session, error) {
+	out, err := runCommandOutput("tmux", "list-sessions", "-F", tmuxFormat("#{session_name}", pathFormat))
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []Session
+	for _, line := range splitLines(out) {
+		name, sessionDir, _ := strings.Cut(line, fieldSep)
+		sessions = append(sessions, Session{Name: name, Path: sessionDir})
+	}
+
+	return sessions, nil
+}
+
+func (tmuxMultiplexer) HasSession(id string) bool {
+	err := runCommand(IO{}, "tmux", "has-session", "-t", id)
+	return err == nil
+}
+
+func (tmuxMultiplexer) SessionPath(id string) (string, error) {
+	out, err := runCommandOutput("tmux", "display-message", "-p", "-t", id, pathFormat)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(out), nil
+}
+
+func (tmuxMultiplexer) FindSession(dir string) (string, bool) {
+	out, err := runCommandOutput("tmux", "list-sessions", "-F", tmuxFormat("#{session_name}", "#{"+pathOption+"}"))
+	if err != nil {
+		return "", false
+	}
+
+	dir = path.Clean(dir)
+	for _, line := range splitLines(out) {
+		name, projectDir, _ := strings.Cut(line, fieldSep)
+		if projectDir != "" && path.Clean(projectDir) == dir {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+func (tmuxMultiplexer) CreateSession(config Config, id, dir string, t Template) error {
+	shell := config.Shell
+	if t.Shell != "" {
+		shell = t.Shell
+	}
+
+	conf, err := projectTmuxConf(dir)
+	if err != nil {
+		return err
+	}
+
+	err = createSession(id, dir, shell, t.Env, newSessionArgs(config, dir), conf)
+	if err != nil {
+		return err
+	}
+
+	options := map[string]string{}
+	for k, v := range config.SessionOptions {
+		options[k] = v
+	}
+	for k, v := range t.SessionOptions {
+		options[k] = v
+	}
+
+	err = setSessionOptions(id, options)
+	if err != nil {
+		return err
+	}
+
+	if t.WindowNaming == "" {
+		t.WindowNaming = config.WindowNaming
+	}
+
+	if t.Async {
+		return startProvision(id, dir, t)
+	}
+
+	return applyTemplate(id, dir, t)
+}
+
+func (tmuxMultiplexer) Attach(config Config, id string) error {
+	followMirrors(id)
+
+	if config.SpawnTerminal {
+		return spawnTerminal(config, id)
+	}
+
+	if insideTmux() {
+		return switchSession(id)
+	}
+
+	err := attachToSession(id)
+	if err != nil {
+		// The session's directory is only needed to recreate it.
+		sessionDir, _ := sessionPath(id)
+		return recoverAttach(config, id, sessionDir, err)
+	}
+
+	return nil
+}
+
+func (tmuxMultiplexer) KillSession(id string) error {
+	return runCommand(IO{}, "tmux", "kill-session", "-t", id)
+}
+
+// zellijMultiplexer manages zellij sessions. zellij has no session options to
+// record the project directory of a session in, so the directories of the
+// sessions tsm created are kept in the state.
+type zellijMultiplexer struct{}
+
+// runningSessions returns the names of the running zellij sessions. Exited
+// sessions, which zellij keeps around to be resurrected, are left out.
+func (zellijMultiplexer) runningSessions() ([]string, error) {
+	out, err := runCommandOutput("zellij", "list-sessions", "--no-formatting")
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, line := range splitLines(out) {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && !strings.Contains(line, "(EXITED") {
+			names = append(names, fields[0])
+		}
+	}
+
+	return names, nil
+}
+
+func (z zellijMultiplexer) Sessions() ([]Session, error) {
+	names, err := z.runningSessions()
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := loadState()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]Session, 0, len(names))
+	for _, name := range names {
+		sessions = append(sessions, Session{Name: name, Path: state.ZellijSessions[name]})
+	}
+
+	return sessions, nil
+}
+
+func (z zellijMultiplexer) HasSession(id string) bool {
+	names, err := z.runningSessions()
+	return err == nil && slices.Contains(names, id)
+}
+
+// SessionPath returns an empty path for sessions created outside tsm.
+func (zellijMultiplexer) SessionPath(id string) (string, error) {
+	state, err := loadState()
+	if err != nil {
+		return "", err
+	}
+
+	return state.ZellijSessions[id], nil
+}
+
+func (z zellijMultiplexer) FindSession(dir string) (string, bool) {
+	sessions, err := z.Sessions()
+	if err != nil {
+		return "", false
+	}
+
+	dir = path.Clean(dir)
+	for _, s := range sessions {
+		if s.Path != "" && path.Clean(s.Path) == dir {
+			return s.Name, true
+		}
+	}
+
+	return "", false
+}
+
+// CreateSession creates a session in the background, with a tab for each
+// window of the template. Session options, tmux configs, and the ordering of
+// window commands have no equivalent in zellij and are ignored.
+func (zellijMultiplexer) CreateSession(config Config, id, dir string, t Template) error {
+	err := checkSessionName(id)
+	if err != nil {
+		return err
+	}
+
+	command := []string{"zellij", "attach", "--create-background", id, "options", "--default-cwd", dir}
+
+	shell := config.Shell
+	if t.Shell != "" {
+		shell = t.Shell
+	}
+	if shell != "" {
+		command = append(command, "--default-shell", shell)
+	}
+
+	if len(t.Windows) > 0 {
+		layout, err := zellijLayout(config, dir, t)
+		if err != nil {
+			return err
+		}
+
+		layoutPath, err := getStatePath(path.Join("layouts", id+".kdl"))
+		if err != nil {
+			return err
+		}
+		err = os.MkdirAll(path.Dir(layoutPath), 0700)
+		if err != nil {
+			return err
+		}
+		err = writeFileAtomic(layoutPath, []byte(layout), 0600)
+		if err != nil {
+			return err
+		}
+
+		command = append(command, "--default-layout", layoutPath)
+	}
+
+	// The session's server inherits the environment of the command
+	// starting it.
+	cmd := newCommand(IO{}, command...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), envList(t.Env)...)
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("tsm: creating zellij session %q: %w", id, err)
+	}
+
+	err = updateState(func(state *State) error {
+		if state.ZellijSessions == nil {
+			state.ZellijSessions = map[string]string{}
+		}
+		state.ZellijSessions[id] = dir
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	recordEvent(EventCreated, id, dir)
+
+	return nil
+}
+
+// Attach attaches to a session in the current terminal or one spawned for
+// it. zellij cannot switch the session of a client from the outside, so
+// tsm refuses to run within a session unless it spawns a terminal.
+func (zellijMultiplexer) Attach(config Config, id string) error {
+	command := []string{"zellij", "attach", id}
+	if config.SpawnTerminal {
+		return spawnTerminalCommand(config, command)
+	}
+
+	if os.Getenv("ZELLIJ") != "" {
+		return errors.New("tsm: zellij cannot switch sessions from within one; detach first or set spawn_terminal")
+	}
+
+	return runCommand(stdIO, command...)
+}
+
+// KillSession kills a session and deletes it, so that creating a session of
+// the same name later does not resurrect it.
+func (zellijMultiplexer) KillSession(id string) error {
+	err := runCommand(IO{}, "zellij", "kill-session", id)
+	if err != nil {
+		return err
+	}
+
+	// An exited session that cannot be deleted is resurrected at worst.
+	_ = runCommand(IO{}, "zellij", "delete-session", id)
+
+	return updateState(func(state *State) error {
+		if _, ok := state.ZellijSessions[id]; !ok {
+			return errStateUnchanged
+		}
+		delete(state.ZellijSessions, id)
+		return nil
+	})
+}
+
+// zellijLayout translates the windows of a template into a zellij layout,
+// with a tab for each window and a pane for the window's command and each of
+// its panes. Commands run in sh and are followed by an interactive shell, as
+// tmux leaves the shell a command was typed into.
+func zellijLayout(config Config, dir string, t Template) (string, error) {
+	var b strings.Builder
+	b.WriteString("layout {\n")
+	for _, w := range t.Windows {
+		w, err := resolveWindow(config, w)
+		if err != nil {
+			return "", err
+		}
+
+		windowDir := resolveDir(dir, w.Dir)
+		b.WriteString("    tab")
+		if w.Name != "" {
+			fmt.Fprintf(&b, " name=%s", kdlString(w.Name))
+		}
+		// Panes side by side are split vertically in zellij's terms.
+		if w.Layout == "even-horizontal" || w.Layout == "main-vertical" {
+			b.WriteString(` split_direction="vertical"`)
+		}
+		b.WriteString(" {\n")
+
+		// The template's env is inherited from the session's server.
+		writeZellijPane(&b, windowDir, w.Env, w.Command)
+		for _, p := range w.Panes {
+			writeZellijPane(&b, resolveDir(windowDir, p.Dir), mergeEnv(w.Env, p.Env), p.Command)
+		}
+
+		b.WriteString("    }\n")
+	}
+	b.WriteString("}\n")
+
+	return b.String(), nil
+}
+
+func writeZellijPane(b *strings.Builder, dir string, env map[string]string, command string) {
+	fmt.Fprintf(b, "        pane cwd=%s", kdlString(dir))
+	if command == "" && len(env) == 0 {
+		b.WriteString("\n")
+		return
+	}
+
+	script := `exec "${SHELL:-sh}"`
+	if command != "" {
+		script = command + "; " + script
+	}
+
+	program, args := "sh", []string{"-c", script}
+	if len(env) > 0 {
+		// env runs sh with the pane's variables set.
+		program, args = "env", append(envList(env), append([]string{program}, args...)...)
+	}
+	fmt.Fprintf(b, " command=%s {\n", kdlString(program))
+
+	b.WriteString("            args")
+	for _, arg := range args {
+		b.WriteString(" " + kdlString(arg))
+	}
+	b.WriteString("\n        }\n")
+}
+
+// kdlString quotes s as a string of zellij's KDL layout format.
+func kdlString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`).Replace(s) + `"`
+}
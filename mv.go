@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// handleMv updates everything tsm knows about a project directory after it
+// was moved on disk: the running session, the registry, pins, history, and
+// saved snapshots.
+func handleMv(configPath string, config Config, args []string) error {
+	if len(args) != 2 {
+		return errors.New("tsm: mv requires the old and the new project path")
+	}
+
+	oldDir, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+
+	newDir, err := filepath.Abs(args[1])
+	if err != nil {
+		return err
+	}
+
+	err = validateTarget(newDir)
+	if err != nil {
+		return err
+	}
+
+	err = moveSession(config, oldDir, newDir)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := findProject(config, oldDir); ok {
+		err = updateConfigFile(configPath, func(config *Config) error {
+			for i, p := range config.Projects {
+				if path.Clean(expandHome(p.Path)) == oldDir {
+					config.Projects[i].Path = newDir
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = updateState(func(state *State) error {
+		for i := range state.Pins {
+			state.Pins[i] = movePath(state.Pins[i], oldDir, newDir)
+		}
+		for i := range state.Archived {
+			state.Archived[i] = movePath(state.Archived[i], oldDir, newDir)
+		}
+		for id, dir := range state.ZellijSessions {
+			state.ZellijSessions[id] = movePath(dir, oldDir, newDir)
+		}
+		for _, sessions := range state.Running {
+			for i := range sessions {
+				sessions[i].Path = movePath(sessions[i].Path, oldDir, newDir)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	store, err := openStateStore()
+	if err != nil {
+		return err
+	}
+	err = store.RewriteHistory(func(entry HistoryEntry) (HistoryEntry, bool) {
+		entry.Path = movePath(entry.Path, oldDir, newDir)
+		return entry, true
+	})
+	if err != nil {
+		return err
+	}
+
+	return moveSnapshots(oldDir, newDir)
+}
+
+// moveSession points the session of a moved project at its new directory.
+// Idle shells in panes below the old directory are moved along, and the
+// session is renamed if its name was derived from the old directory.
+func moveSession(config Config, oldDir, newDir string) error {
+	id, ok := sessionForTarget(config, oldDir)
+	if !ok {
+		return nil
+	}
+
+	err := runCommand(IO{}, "tmux", "set-option", "-t", id, pathOption, newDir)
+	if err != nil {
+		return err
+	}
+
+	panes, err := runCommandOutput("tmux", "list-panes", "-s", "-t", id,
+		"-F", tmuxFormat("#{pane_id}", "#{pane_current_path}", "#{pane_current_command}"))
+	if err != nil {
+		return err
+	}
+
+	for _, line := range splitLines(panes) {
+		fields := strings.Split(line, fieldSep)
+		if len(fields) != 3 || !isShell(fields[2]) {
+			continue
+		}
+
+		moved := movePath(fields[1], oldDir, newDir)
+		if moved == fields[1] {
+			continue
+		}
+
+		err = runCommand(IO{}, "tmux", "send-keys", "-t", fields[0], "cd "+shellQuote(moved), "Enter")
+		if err != nil {
+			return err
+		}
+	}
+
+	newID := sessionID(config, newDir)
+	if id == sessionID(config, oldDir) && newID != id && !sessionExists(newID) {
+		err = runCommand(IO{}, "tmux", "rename-session", "-t", id, newID)
+		if err != nil {
+			return err
+		}
+		id = newID
+	}
+
+	fmt.Fprintf(stdIO.Stdout, "Moved session %q to %s\n", id, newDir)
+
+	return nil
+}
+
+func moveSnapshots(oldDir, newDir string) error {
+	snapshotsPath, err := getSnapshotsPath()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(snapshotsPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	snapshots, err := readSnapshots(snapshotsPath)
+	if err != nil {
+		return err
+	}
+
+	for id, snapshot := range snapshots {
+		snapshots[id] = snapshot.move(oldDir, newDir)
+	}
+
+	return writeSnapshots(snapshotsPath, snapshots)
+}
+
+// move returns the snapshot with every path below oldDir moved to newDir.
+func (s Snapshot) move(oldDir, newDir string) Snapshot {
+	s.Path = movePath(s.Path, oldDir, newDir)
+	for i, window := range s.Windows {
+		for j, pane := range window.Panes {
+			s.Windows[i].Panes[j].Path = movePath(pane.Path, oldDir, newDir)
+		}
+	}
+
+	return s
+}
+
+// movePath rewrites p if it is oldDir or below it.
+func movePath(p, oldDir, newDir string) string {
+	if p == oldDir {
+		return newDir
+	}
+
+	if rel, ok := strings.CutPrefix(p, oldDir+"/"); ok {
+		return path.Join(newDir, rel)
+	}
+
+	return p
+}
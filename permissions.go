@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// warnPermissions warns about the config file and state files that users
+// other than the owner may access. tsm writes them readable by the owner
+// only, since they can hold paths, environment variables, and remote hosts,
+// but files written by older versions or by hand may be more permissive.
+func warnPermissions(configPath string) {
+	paths := []string{configPath}
+	if stateDir, err := getStateDir(); err == nil {
+		entries, _ := os.ReadDir(stateDir)
+		for _, entry := range entries {
+			// Lock files are empty.
+			if entry.Type().IsRegular() && !strings.HasSuffix(entry.Name(), ".lock") {
+				paths = append(paths, path.Join(stateDir, entry.Name()))
+			}
+		}
+	}
+
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil || info.Mode().Perm()&0077 == 0 {
+			continue
+		}
+
+		fmt.Fprintf(stdIO.Stderr, "tsm: %s is accessible by other users (mode %04o); restrict it with: chmod 600 %s\n",
+			p, info.Mode().Perm(), shellQuote(p))
+	}
+}
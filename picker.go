@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Keys bound to actions on the highlighted picker entry.
+const (
+	pickerKeyKill   = "ctrl-x"
+	pickerKeyRename = "ctrl-r"
+	pickerKeyPin    = "ctrl-p"
+	pickerKeyEdit   = "ctrl-e"
+	pickerKeyMove   = "ctrl-o"
+	pickerKeyCreate = "ctrl-n"
+)
+
+// pickerExpect returns the value of fzf's --expect option, which makes fzf
+// exit on the action keys and report the key pressed.
+func pickerExpect() string {
+	return strings.Join([]string{pickerKeyKill, pickerKeyRename, pickerKeyPin, pickerKeyEdit, pickerKeyMove, pickerKeyCreate}, ",")
+}
+
+// runFzf runs fzf on the given input with the given arguments and returns
+// what it prints. errPickerCancelled is returned if nothing was selected.
+func runFzf(input io.Reader, args ...string) (string, error) {
+	var out bytes.Buffer
+	cmd := newCommand(IO{Stdin: input, Stdout: &out, Stderr: os.Stderr}, append([]string{"fzf"}, args...)...)
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.Is(err, exec.ErrNotFound) {
+		return "", errors.New("tsm: fzf not found; install it (https://github.com/junegunn/fzf)")
+	} else if errors.As(err, &exitErr) && (exitErr.ExitCode() == 130 || exitErr.ExitCode() == 1) {
+		return "", errPickerCancelled
+	} else if err != nil {
+		return "", fmt.Errorf("tsm: fzf failed: %w", err)
+	}
+
+	return out.String(), nil
+}
+
+// pickerCommand returns the fzf command line of the picker without the
+// user's arguments. Only the path of an entry is matched and previewed, not
+// the indicators displayed after it.
+func pickerCommand(config Config) []string {
+	// The query names the projects created with pickerKeyCreate.
+	command := []string{"fzf", "--expect", pickerExpect(), "--print-query"}
+	if len(config.Picker.Git) > 0 || config.Picker.Preview != nil {
+		command = append(command, "--delimiter", "\t", "--nth", "1")
+	}
+	if config.Picker.Preview != nil {
+		if exe, err := os.Executable(); err == nil {
+			command = append(command, "--preview", shellQuote(exe)+" preview {1}")
+		}
+	}
+	if config.pickerQuery != "" {
+		command = append(command, "--query", config.pickerQuery)
+	}
+
+	return command
+}
+
+// Ways of grouping picker entries.
+const (
+	// GroupBaseDir labels directories with the name of their base dir.
+	GroupBaseDir = "base_dir"
+	// GroupTag labels registered projects with their first tag.
+	GroupTag = "tag"
+)
+
+// pickerGroup returns the group of a picker entry, or an empty string if it
+// belongs to none. Files are grouped with their directory.
+func pickerGroup(config Config, entry string) string {
+	dir := entry
+	if _, ok := findFileTarget(config, entry); ok {
+		dir = path.Dir(entry)
+	}
+
+	switch config.Picker.Group {
+	case GroupBaseDir:
+		parent := path.Dir(dir)
+		for _, baseDir := range config.BaseDirs {
+			if path.Clean(expandHome(baseDir)) == parent {
+				return path.Base(parent)
+			}
+		}
+	case GroupTag:
+		if p, ok := findProject(config, dir); ok && len(p.Tags) > 0 {
+			return p.Tags[0]
+		}
+	}
+
+	return ""
+}
+
+// pickerGroups returns the names of all groups, known without listing the
+// entries.
+func pickerGroups(config Config) []string {
+	var groups []string
+	switch config.Picker.Group {
+	case GroupBaseDir:
+		for _, baseDir := range config.BaseDirs {
+			groups = append(groups, path.Base(path.Clean(expandHome(baseDir))))
+		}
+	case GroupTag:
+		for _, p := range config.Projects {
+			groups = append(groups, p.Tags...)
+		}
+	}
+
+	return groups
+}
+
+// splitGroupFilter splits a picker query starting with the name of a group
+// and a slash, e.g. "work/api", into the group and the rest of the query.
+func splitGroupFilter(config Config, query string) (string, string) {
+	group, rest, ok := strings.Cut(query, "/")
+	if ok && group != "" && slices.Contains(pickerGroups(config), group) {
+		return group, rest
+	}
+
+	return "", query
+}
+
+// stripGroupLabel removes the label of a picker line's group.
+func stripGroupLabel(line string) string {
+	if !strings.HasPrefix(line, "[") {
+		return line
+	}
+
+	if _, rest, ok := strings.Cut(line, "] "); ok {
+		return rest
+	}
+
+	return line
+}
+
+// pickerLine returns the line listing an entry in the picker, which is the
+// entry, labeled with its group, followed by its git indicators, if any are
+// enabled.
+func pickerLine(config Config, group, entry string) string {
+	line := entry
+	if group != "" {
+		// Paths never start with a bracket, so the label is told apart.
+		line = "[" + group + "] " + entry
+	}
+
+	enabled := config.Picker.Git
+	if config.Picker.Preview != nil {
+		enabled = slices.DeleteFunc(slices.Clone(enabled), func(indicator string) bool {
+			return indicator == GitAheadBehind
+		})
+	}
+
+	if len(enabled) == 0 {
+		return line
+	}
+
+	ctx, cancel := timeoutContext(config, TimeoutGit)
+	defer cancel()
+
+	indicators := gitIndicators(ctx, enabled, entry)
+	if indicators == "" {
+		return line
+	}
+
+	return line + "\t" + indicators
+}
+
+// walkPickerEntries calls fn for every entry of the picker. By default,
+// pinned entries come first, then project directories, each followed by its
+// file targets, then sessions on remote servers, and finally pull requests.
+// Every entry is listed once, at its first position.
+func walkPickerEntries(config Config, fn func(string) error) error {
+	if config.stdinCandidates {
+		return walkStdin(fn)
+	}
+
+	if len(config.FileTargets) == 0 {
+		return walkOrigins(config, origins(config), fn)
+	}
+
+	// File targets are listed after the directory holding them.
+	return walkOrigins(config, origins(config), func(p string) error {
+		err := fn(p)
+		if err != nil {
+			return err
+		}
+
+		return walkFileTargets(config, p, fn)
+	})
+}
+
+// walkStdin calls fn for every path read from stdin, one per line, as
+// produced by tools such as fd.
+func walkStdin(fn func(string) error) error {
+	scanner := bufio.NewScanner(stdIO.Stdin)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		p, err := filepath.Abs(line)
+		if err != nil {
+			return err
+		}
+
+		err = fn(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// readPins returns the pinned picker entries. Pinned directories that no
+// longer exist are left out.
+func readPins() []string {
+	state, err := loadState()
+	if err != nil {
+		return nil
+	}
+
+	return slices.DeleteFunc(state.Pins, func(p string) bool {
+		if _, ok := parseRemoteSession(p); ok {
+			return false
+		}
+
+		info, err := os.Stat(p)
+		return err != nil || !info.IsDir()
+	})
+}
+
+// handlePickerAction performs the action bound to key on a picker entry.
+func handlePickerAction(config Config, key, target string) error {
+	switch key {
+	case pickerKeyKill:
+		id, ok := sessionForTarget(config, target)
+		if !ok {
+			return nil
+		}
+
+		ok, err := confirmUnlocked(id, "Kill")
+		if err != nil || !ok {
+			return err
+		}
+
+		return trashSession(config, id)
+	case pickerKeyRename:
+		if !usingTmux() {
+			return fmt.Errorf("tsm: renaming sessions requires the %s multiplexer", MultiplexerTmux)
+		}
+
+		id, ok := sessionForTarget(config, target)
+		if !ok {
+			return nil
+		}
+
+		name, err := prompt("Rename session %q to: ", id)
+		if err != nil || name == "" {
+			return err
+		}
+
+		return runCommand(IO{}, "tmux", "rename-session", "-t", id, cleanID(config, name))
+	case pickerKeyPin:
+		return updateState(func(state *State) error {
+			if i := slices.Index(state.Pins, target); i >= 0 {
+				state.Pins = slices.Delete(state.Pins, i, i+1)
+			} else {
+				state.Pins = append(state.Pins, target)
+			}
+
+			return nil
+		})
+	default:
+		return fmt.Errorf("tsm: unknown picker key %q", key)
+	}
+}
+
+// sessionForTarget returns the running session opened for a picker entry.
+func sessionForTarget(config Config, target string) (string, bool) {
+	if r, ok := parseRemoteSession(target); ok {
+		id := r.localID(config)
+		return id, sessionExists(id)
+	}
+
+	if id, ok := findSessionForPath(target); ok {
+		return id, true
+	}
+
+	id := sessionID(config, target)
+	if existingDir, err := sessionPath(id); err == nil && path.Clean(existingDir) == path.Clean(target) {
+		return id, true
+	}
+
+	return "", false
+}
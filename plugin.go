@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// pluginKeys are the tmux options of the plugin that bind a key, the key
+// bound by default, and the tsm arguments the key runs. An empty default
+// leaves the key unbound unless the option is set.
+var pluginKeys = []struct {
+	option     string
+	defaultKey string
+	args       string
+	popup      bool
+}{
+	{option: "@tsm-key", defaultKey: "T", popup: true},
+	{option: "@tsm-menu-key", args: "--menu"},
+	{option: "@tsm-last-key", args: "last"},
+	{option: "@tsm-ui-key", args: "ui", popup: true},
+}
+
+// handlePluginInit sets up tsm within a running tmux server, as the plugin
+// entry script tsm.tmux does when loaded by TPM. Keys are bound according to
+// the plugin's tmux options, the time tracking hooks are installed if
+// @tsm-time-tracking is on, and the sessions of anchors and pins are created
+// if @tsm-bootstrap is on.
+func handlePluginInit(config Config, args []string) error {
+	if len(args) > 0 {
+		return errors.New("tsm: plugin-init takes no arguments")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	for _, k := range pluginKeys {
+		key := tmuxGlobalOption(k.option)
+		if key == "" {
+			key = k.defaultKey
+		}
+		if key == "" || key == "none" {
+			continue
+		}
+
+		command := strings.TrimSpace(shellQuote(exe) + " " + k.args)
+		binding := []string{"run-shell", "-b", command}
+		if k.popup {
+			binding = []string{"display-popup", "-E", "-w", "80%", "-h", "70%", command}
+		}
+
+		err = runCommand(IO{}, append([]string{"tmux", "bind-key", key}, binding...)...)
+		if err != nil {
+			return err
+		}
+	}
+
+	if tmuxGlobalOption("@tsm-time-tracking") == "on" {
+		err = installTimeHooks()
+		if err != nil {
+			return err
+		}
+	}
+
+	if tmuxGlobalOption("@tsm-bootstrap") == "on" {
+		_, err = bootstrapSessions(config)
+		return err
+	}
+
+	return nil
+}
+
+// tmuxGlobalOption returns the value of a global tmux option, or an empty
+// string if it is not set.
+func tmuxGlobalOption(option string) string {
+	out, err := runCommandOutput("tmux", "show-option", "-gqv", option)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(out)
+}
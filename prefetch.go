@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// prefetchOption is the tmux user option marking sessions created by
+// prefetch.
+const prefetchOption = "@tsm_prefetched"
+
+// Defaults of the prefetch settings.
+const (
+	defaultPrefetchSessions   = 1
+	defaultPrefetchMaxRunning = 10
+)
+
+type PrefetchConfig struct {
+	// Sessions is how many of the projects most likely to be opened next
+	// get a session ahead of time. It defaults to 1.
+	Sessions int `json:"sessions,omitempty"`
+	// MaxRunning stops prefetching once this many sessions are running. It
+	// defaults to 10.
+	MaxRunning int `json:"max_running,omitempty"`
+	// MaxLoad stops prefetching while the one-minute load average per CPU
+	// is higher, e.g. 0.8. There is no limit when it is 0.
+	MaxLoad float64 `json:"max_load,omitempty"`
+}
+
+// startPrefetch runs prefetch in a detached tsm process if it is enabled, so
+// that switching never waits for the sessions being created.
+func startPrefetch(config Config) error {
+	if config.Prefetch == nil || !usingTmux() {
+		return nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd := newCommand(IO{}, exe, "prefetch")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	return cmd.Process.Release()
+}
+
+// handlePrefetch creates detached sessions for the projects most likely to be
+// switched to next, judged by frecency, the time of day, and which projects
+// usually follow the current one. Sessions it created earlier that were never
+// switched to and are no longer likely are killed, so prefetched sessions do
+// not pile up.
+func handlePrefetch(config Config, args []string) error {
+	if len(args) > 0 {
+		return errors.New("tsm: prefetch takes no arguments")
+	} else if config.Mode == ModeWindows {
+		return errors.New("tsm: prefetch requires a session per project")
+	}
+
+	var prefetch PrefetchConfig
+	if config.Prefetch != nil {
+		prefetch = *config.Prefetch
+	}
+	if prefetch.Sessions == 0 {
+		prefetch.Sessions = defaultPrefetchSessions
+	}
+	if prefetch.MaxRunning == 0 {
+		prefetch.MaxRunning = defaultPrefetchMaxRunning
+	}
+
+	// Switches in quick succession start several prefetches, which must not
+	// create the same sessions.
+	lockPath, err := getStatePath("prefetch")
+	if err != nil {
+		return err
+	}
+	unlock, err := lockFile(lockPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := loadState()
+	if err != nil {
+		return err
+	}
+
+	out, err := runCommandOutput("tmux", "list-sessions", "-F", tmuxFormat(
+		"#{session_name}", pathFormat, "#{session_attached}", "#{session_last_attached}", "#{"+prefetchOption+"}"))
+	if err != nil {
+		return err
+	}
+
+	var current string
+	if len(state.History) > 0 {
+		current = state.History[len(state.History)-1].Path
+	}
+
+	running := pathSet{}
+	var sessions [][]string
+	for _, line := range splitLines(out) {
+		fields := strings.Split(line, fieldSep)
+		if len(fields) != 5 {
+			continue
+		}
+		running.add(fields[1])
+		sessions = append(sessions, fields)
+	}
+
+	var likely []string
+	for _, dir := range likelyProjects(state.History, current, time.Now()) {
+		if len(likely) == prefetch.Sessions {
+			break
+		}
+		if _, ok := parseRemoteSession(dir); ok || validateTarget(dir) != nil {
+			continue
+		}
+		likely = append(likely, dir)
+	}
+
+	count := len(sessions)
+	for _, fields := range sessions {
+		// Sessions switched to since are the user's to keep.
+		id, dir, attached, lastAttached, prefetched := fields[0], fields[1], fields[2], fields[3], fields[4]
+		if prefetched != "1" || attached != "0" || lastAttached != "" || slices.Contains(likely, dir) {
+			continue
+		}
+
+		runKillHooks(config, id, dir)
+		if killSession(id) == nil {
+			recordEvent(EventKilled, id, dir)
+			count--
+		}
+	}
+
+	for _, dir := range likely {
+		if running[canonicalPath(dir)] {
+			continue
+		} else if count >= prefetch.MaxRunning || overloaded(prefetch.MaxLoad) {
+			return nil
+		}
+
+		// A session of another project with the same name is left for the
+		// user to resolve when switching.
+		id := sessionID(config, dir)
+		if sessionExists(id) {
+			continue
+		}
+
+		err = createProjectSession(config, id, dir)
+		if err != nil {
+			return err
+		}
+		count++
+
+		err = runCommand(IO{}, "tmux", "set-option", "-t", id, prefetchOption, "1")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// likelyProjects orders the projects of the history by how likely they are
+// to be switched to next from the current project. On top of frecency,
+// switches made around the same time of day and switches made right after
+// the current project count.
+func likelyProjects(history []HistoryEntry, current string, now time.Time) []string {
+	scores := historyFrecency(history, now)
+	for i, entry := range history {
+		if timeOfDayDistance(entry.Time, now) < time.Hour {
+			scores[entry.Path] += 1
+		}
+		if i > 0 && history[i-1].Path == current && entry.Path != current {
+			scores[entry.Path] += 2
+		}
+	}
+	delete(scores, current)
+
+	dirs := make([]string, 0, len(scores))
+	for dir := range scores {
+		dirs = append(dirs, dir)
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		if scores[dirs[i]] != scores[dirs[j]] {
+			return scores[dirs[i]] > scores[dirs[j]]
+		}
+		return dirs[i] < dirs[j]
+	})
+
+	return dirs
+}
+
+// timeOfDayDistance returns how far apart the times of day of a and b are,
+// regardless of the date.
+func timeOfDayDistance(a, b time.Time) time.Duration {
+	a, b = a.Local(), b.Local()
+	d := time.Duration(a.Hour()-b.Hour())*time.Hour + time.Duration(a.Minute()-b.Minute())*time.Minute
+	if d < 0 {
+		d = -d
+	}
+	if d > 12*time.Hour {
+		d = 24*time.Hour - d
+	}
+
+	return d
+}
+
+// overloaded reports whether the one-minute load average per CPU exceeds
+// maxLoad. The load is only known on Linux, elsewhere there is no limit.
+func overloaded(maxLoad float64) bool {
+	if maxLoad <= 0 {
+		return false
+	}
+
+	d, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return false
+	}
+
+	fields := strings.Fields(string(d))
+	if len(fields) == 0 {
+		return false
+	}
+
+	load, err := strconv.ParseFloat(fields[0], 64)
+	return err == nil && load/float64(runtime.NumCPU()) > maxLoad
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultPreviewTimeouts bound each source of enrichment in the preview
+// unless picker.preview.timeouts or timeouts sets another. They are shorter
+// than the defaults of timeouts to keep the preview responsive.
+var defaultPreviewTimeouts = map[string]time.Duration{
+	TimeoutGit: 2 * time.Second,
+	TimeoutSSH: 5 * time.Second,
+	TimeoutGH:  5 * time.Second,
+}
+
+type PreviewConfig struct {
+	// Timeouts bound the enrichment from each source, e.g. {"ssh": "3s"},
+	// in place of timeouts. See the Timeout constants for git, ssh, and
+	// gh.
+	Timeouts map[string]string `json:"timeouts,omitempty"`
+}
+
+// previewTimeout returns how long the preview waits for source, one of the
+// Timeout constants. Timeouts were checked by checkTimeouts.
+func previewTimeout(config Config, source string) time.Duration {
+	if config.Picker.Preview != nil {
+		if d, err := time.ParseDuration(config.Picker.Preview.Timeouts[source]); err == nil {
+			return d
+		}
+	}
+	if d, err := time.ParseDuration(config.Timeouts[source]); err == nil {
+		return d
+	}
+
+	return defaultPreviewTimeouts[source]
+}
+
+// handlePreview prints the details of a picker entry that are too slow to
+// compute for every entry up front: a project's session and git state, the
+// sessions on a remote, or the description of a pull request.
+func handlePreview(config Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New("tsm: preview requires a picker entry")
+	}
+	entry := stripGroupLabel(args[0])
+
+	if r, ok := parseRemoteSession(entry); ok {
+		return previewRemote(config, r)
+	} else if pr, ok := parsePullRequest(entry); ok {
+		return previewPullRequest(config, pr)
+	}
+
+	return previewProject(config, entry)
+}
+
+func previewProject(config Config, dir string) error {
+	fmt.Fprintln(stdIO.Stdout, dir)
+
+	if id, ok := sessionForTarget(config, dir); ok {
+		status := "running"
+		if activity, ok := sessionActivity()[dir]; ok {
+			status = idleStatus(time.Since(activity))
+		}
+		fmt.Fprintf(stdIO.Stdout, "\nsession %s (%s)\n", id, status)
+
+		windows, err := runCommandOutput("tmux", "list-windows", "-t", id,
+			"-F", "  #{window_index}: #{window_name} (#{pane_current_command})")
+		if err == nil {
+			fmt.Fprint(stdIO.Stdout, windows)
+		}
+	}
+
+	if gitBranch(dir) == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(runContext, previewTimeout(config, TimeoutGit))
+	defer cancel()
+
+	line := gitIndicators(ctx, []string{GitBranch, GitDirty, GitAheadBehind, GitStash}, dir)
+	if ctx.Err() != nil {
+		fmt.Fprintf(stdIO.Stdout, "\ngit %s (timed out)\n", gitBranch(dir))
+	} else {
+		fmt.Fprintf(stdIO.Stdout, "\ngit %s\n", line)
+	}
+
+	return nil
+}
+
+// previewRemote lists the sessions on a remote, or the windows of a remote
+// session.
+func previewRemote(config Config, r RemoteSession) error {
+	fmt.Fprintln(stdIO.Stdout, r.String())
+	timeout := previewTimeout(config, TimeoutSSH)
+
+	command := []string{"tmux", "list-sessions", "-F", shellQuote("#{session_name} (#{session_windows} windows)")}
+	if r.Name != "" {
+		command = []string{"tmux", "list-windows", "-t", shellQuote(r.Name),
+			"-F", shellQuote("#{window_index}: #{window_name} (#{pane_current_command})")}
+	}
+
+	seconds := strconv.Itoa(max(int(timeout.Seconds()), 1))
+	out, err := runCommandOutputTimeout(timeout, r.sshCommand([]string{"-o", "BatchMode=yes", "-o", "ConnectTimeout=" + seconds}, command...)...)
+	if err != nil {
+		fmt.Fprintf(stdIO.Stdout, "\nunreachable: %v\n", err)
+		return nil
+	}
+
+	fmt.Fprintf(stdIO.Stdout, "\n%s", out)
+	return nil
+}
+
+func previewPullRequest(config Config, pr PullRequest) error {
+	out, err := runCommandOutputTimeout(previewTimeout(config, TimeoutGH), "gh", "pr", "view", strconv.Itoa(pr.Number), "--repo", pr.Repo)
+	if err != nil {
+		fmt.Fprintf(stdIO.Stdout, "%s\n\nunavailable: %v\n", pr, err)
+		return nil
+	}
+
+	fmt.Fprint(stdIO.Stdout, out)
+	return nil
+}
+
+// runCommandOutputTimeout is runCommandOutput for commands that are killed
+// after timeout.
+func runCommandOutputTimeout(timeout time.Duration, command ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(runContext, timeout)
+	defer cancel()
+
+	var out strings.Builder
+	cmd := newCommandContext(ctx, IO{Stdout: &out}, command...)
+	// Children of a killed command may keep its output open.
+	cmd.WaitDelay = 100 * time.Millisecond
+
+	err := cmd.Run()
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return "", errors.New("timed out")
+	}
+
+	return out.String(), err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"gopkg.in/yaml.v3"
+)
+
+// projectFileName is the file in a project directory holding settings the
+// project carries itself.
+const projectFileName = ".tsm.yaml"
+
+// ProjectFile holds the settings of a project's .tsm.yaml.
+type ProjectFile struct {
+	// TmuxConf is a tmux config file sourced into the project's new
+	// sessions, relative to the project directory unless absolute.
+	TmuxConf string `yaml:"tmux_conf"`
+}
+
+// readProjectFile reads the .tsm.yaml of a project directory, if it has one.
+func readProjectFile(dir string) (ProjectFile, error) {
+	p := path.Join(dir, projectFileName)
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return ProjectFile{}, nil
+	} else if err != nil {
+		return ProjectFile{}, err
+	}
+	defer f.Close()
+
+	var file ProjectFile
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	err = dec.Decode(&file)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return ProjectFile{}, fmt.Errorf("tsm: %s: %w", p, err)
+	}
+
+	return file, nil
+}
+
+// projectTmuxConf returns the tmux config file named by the .tsm.yaml of a
+// project directory, or an empty string if there is none.
+func projectTmuxConf(dir string) (string, error) {
+	file, err := readProjectFile(dir)
+	if err != nil || file.TmuxConf == "" {
+		return "", err
+	}
+
+	conf := expandHome(file.TmuxConf)
+	if !path.IsAbs(conf) {
+		conf = path.Join(dir, conf)
+	}
+
+	// A missing file would fail the session after it was created.
+	if !fileExists(conf) {
+		return "", fmt.Errorf("tsm: tmux_conf %s in %s does not exist", conf, path.Join(dir, projectFileName))
+	}
+
+	return conf, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// startProvision applies a template to a new session in a detached tsm
+// process so that switching to the session does not wait for slow windows.
+// The template is passed along as JSON since it may not be looked up by name.
+func startProvision(id, targetDir string, t Template) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	d, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	cmd := newCommand(IO{}, exe, "provision", id, targetDir, string(d))
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	return cmd.Process.Release()
+}
+
+// handleProvision is run by startProvision. It applies the template and
+// reports the outcome with notify since there is no terminal to print to.
+func handleProvision(config Config, args []string) error {
+	if len(args) != 3 {
+		return errors.New("tsm: provision requires a session, a directory, and a template")
+	}
+	id, targetDir := args[0], args[1]
+
+	var t Template
+	err := json.Unmarshal([]byte(args[2]), &t)
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("tsm: session %s is ready", id)
+	err = applyTemplate(id, targetDir, t)
+	if err != nil {
+		message = fmt.Sprintf("tsm: setting up session %s failed: %v", id, err)
+	}
+
+	return notify(config, id, message)
+}
+
+// notify runs the configured notify command with the session and message in
+// its environment, or displays the message on every tmux client.
+func notify(config Config, id, message string) error {
+	if config.Notify != "" {
+		cmd := newCommand(IO{}, "sh", "-c", config.Notify)
+		cmd.Env = append(os.Environ(), "TSM_SESSION="+id, "TSM_MESSAGE="+message)
+		return cmd.Run()
+	}
+
+	clients, err := runCommandOutput("tmux", "list-clients", "-F", "#{client_name}")
+	if err != nil {
+		return err
+	}
+
+	for _, client := range splitLines(clients) {
+		err = runCommand(IO{}, "tmux", "display-message", "-c", client, message)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
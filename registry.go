@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// ProjectConfig is an explicitly registered project. Registered projects are
+// listed alongside the directories discovered in base dirs, regardless of
+// where they live on disk.
+type ProjectConfig struct {
+	// Name is used as the session name. It defaults to the session name
+	// derived from the path.
+	Name string `json:"name,omitempty"`
+	Path string `json:"path"`
+	// Template overrides the default template for this project.
+	Template string `json:"template,omitempty"`
+	// Tags group the project in the picker by the first one.
+	Tags []string `json:"tags,omitempty"`
+	// NewSessionArgs are passed to tmux new-session after the configured
+	// new_session_args.
+	NewSessionArgs []string `json:"new_session_args,omitempty"`
+	// OnKill lists shell commands run after those of the template when tsm
+	// kills the project's session.
+	OnKill []string `json:"on_kill,omitempty"`
+}
+
+// findProject returns the registered project rooted at dir.
+func findProject(config Config, dir string) (ProjectConfig, bool) {
+	dir = path.Clean(dir)
+	for _, p := range config.Projects {
+		if path.Clean(expandHome(p.Path)) == dir {
+			return p, true
+		}
+	}
+
+	return ProjectConfig{}, false
+}
+
+func handleAdd(configPath string, args []string) error {
+	flags := flag.NewFlagSet("add", flag.ExitOnError)
+	name := flags.String("name", "", "")
+	template := flags.String("template", "", "")
+	tags := flags.String("tags", "", "")
+	flags.Parse(args)
+
+	target := "."
+	if flags.NArg() > 0 {
+		target = flags.Arg(0)
+	}
+
+	target, err := filepath.Abs(target)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("tsm: %s is not a directory", target)
+	}
+
+	return updateConfigFile(configPath, func(config *Config) error {
+		project := ProjectConfig{Name: *name, Path: target, Template: *template}
+		if *tags != "" {
+			project.Tags = strings.Split(*tags, ",")
+		}
+
+		for i, p := range config.Projects {
+			if path.Clean(expandHome(p.Path)) == target {
+				config.Projects[i] = project
+				return nil
+			}
+		}
+
+		config.Projects = append(config.Projects, project)
+		return nil
+	})
+}
+
+func handleRemove(configPath string, args []string) error {
+	if len(args) != 1 {
+		return errors.New("tsm: remove requires a project name or path")
+	}
+
+	return updateConfigFile(configPath, func(config *Config) error {
+		for i, p := range config.Projects {
+			if p.Name == args[0] || path.Clean(expandHome(p.Path)) == path.Clean(args[0]) {
+				config.Projects = append(config.Projects[:i], config.Projects[i+1:]...)
+				return nil
+			}
+		}
+
+		return fmt.Errorf("tsm: no registered project %q", args[0])
+	})
+}
+
+// updateConfigFile modifies the config file at configPath in place. Only the
+// file itself is read and written, so settings from included files are never
+// copied into it. Settings unknown to this version of tsm are preserved.
+func updateConfigFile(configPath string, update func(*Config) error) error {
+	unlock, err := lockFile(configPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, err := os.ReadFile(configPath)
+	if err != nil {
+		return err
+	}
+
+	var raw map[string]any
+	err = json.Unmarshal(f, &raw)
+	if err != nil {
+		return err
+	}
+
+	var config Config
+	err = json.Unmarshal(f, &config)
+	if err != nil {
+		return err
+	}
+
+	err = update(&config)
+	if err != nil {
+		return err
+	}
+
+	d, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	var updated map[string]any
+	err = json.Unmarshal(d, &updated)
+	if err != nil {
+		return err
+	}
+
+	// Only keys present in the file or set by the update are written back
+	// so that the defaults of unset options are not spelled out.
+	for key, value := range updated {
+		if _, ok := raw[key]; ok || !isZeroJSON(value) {
+			raw[key] = value
+		}
+	}
+
+	// Options cleared by the update are omitted when marshaling and must be
+	// removed explicitly.
+	for _, key := range configKeys() {
+		if _, ok := updated[key]; !ok {
+			delete(raw, key)
+		}
+	}
+
+	d, err = json.MarshalIndent(raw, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(configPath, append(d, '\n'), 0600)
+}
+
+// configKeys returns the top level keys of the config file.
+func configKeys() []string {
+	var keys []string
+
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			keys = append(keys, name)
+		}
+	}
+
+	return keys
+}
+
+func isZeroJSON(value any) bool {
+	switch value := value.(type) {
+	case nil:
+		return true
+	case []any:
+		return len(value) == 0
+	case map[string]any:
+		return len(value) == 0
+	case string:
+		return value == ""
+	case bool:
+		return !value
+	case float64:
+		return value == 0
+	default:
+		return false
+	}
+}
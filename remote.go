@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const remoteScheme = "ssh://"
+
+// RemoteSession is a session on a tmux server reached over SSH.
+type RemoteSession struct {
+	// Host is the SSH destination, e.g. "user@host".
+	Host string
+	Port string
+	Name string
+}
+
+// String formats the session as it is listed in the picker, e.g.
+// "ssh://user@host:2222/api".
+func (r RemoteSession) String() string {
+	host := r.Host
+	if r.Port != "" {
+		host += ":" + r.Port
+	}
+
+	return remoteScheme + host + "/" + r.Name
+}
+
+// parseRemoteSession parses a remote, optionally followed by a session name
+// as in "ssh://host/session".
+func parseRemoteSession(s string) (RemoteSession, bool) {
+	rest, ok := strings.CutPrefix(s, remoteScheme)
+	if !ok {
+		return RemoteSession{}, false
+	}
+
+	host, name, _ := strings.Cut(rest, "/")
+
+	// The port is only split off after the user, which may contain a colon.
+	var port string
+	if i := strings.LastIndexByte(host, ':'); i > strings.LastIndexByte(host, '@') {
+		host, port = host[:i], host[i+1:]
+	}
+
+	if host == "" {
+		return RemoteSession{}, false
+	}
+
+	return RemoteSession{Host: host, Port: port, Name: name}, true
+}
+
+// localID returns the name of the local session the remote session is opened
+// in when switching to it from inside tmux.
+func (r RemoteSession) localID(config Config) string {
+	if r.Name == "" {
+		return cleanID(config, strings.ReplaceAll(r.Host, "@", "-"))
+	}
+
+	return cleanID(config, strings.ReplaceAll(r.Host, "@", "-")+"-"+r.Name)
+}
+
+// sshCommand returns the command running command on the remote's host.
+// Arguments are passed through the remote shell and must be quoted.
+func (r RemoteSession) sshCommand(options []string, command ...string) []string {
+	ssh := append([]string{"ssh"}, options...)
+	if r.Port != "" {
+		ssh = append(ssh, "-p", r.Port)
+	}
+
+	return append(append(ssh, r.Host), command...)
+}
+
+// walkRemoteSessions calls fn for every session on each configured remote.
+// Remotes that cannot be reached without interaction are skipped so that an
+// offline host does not hold up the picker. With the preview enabled, the
+// remotes themselves are listed instead and their sessions are previewed.
+func walkRemoteSessions(config Config, fn func(string) error) error {
+	for _, remote := range config.Remotes {
+		r, ok := parseRemoteSession(remote)
+		if !ok {
+			return fmt.Errorf("tsm: invalid remote %q: expected ssh://[user@]host[:port]", remote)
+		}
+
+		if config.Picker.Preview != nil {
+			r.Name = ""
+			err := fn(r.String())
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		timeout := operationTimeout(config, TimeoutSSH)
+		seconds := strconv.Itoa(max(int(timeout.Seconds()), 1))
+		out, err := runCommandOutputTimeout(timeout, r.sshCommand([]string{"-o", "BatchMode=yes", "-o", "ConnectTimeout=" + seconds},
+			"tmux", "list-sessions", "-F", shellQuote("#{session_name}"))...)
+		if err != nil {
+			continue
+		}
+
+		for _, name := range splitLines(out) {
+			r.Name = name
+
+			err = fn(r.String())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// switchToRemoteSession attaches to a session on a remote tmux server. Inside
+// tmux, the remote session is opened in a local session of its own so that
+// it can be switched to like any other.
+func switchToRemoteSession(config Config, r RemoteSession) error {
+	attach := r.sshCommand([]string{"-t"}, "tmux", "attach", "-t", shellQuote(r.Name))
+	if r.Name == "" {
+		// Without a session, the most recent one is attached to.
+		attach = r.sshCommand([]string{"-t"}, "tmux", "attach", "||", "tmux", "new-session")
+	}
+
+	if config.SpawnTerminal {
+		return spawnTerminalCommand(config, attach)
+	}
+
+	if !insideTmux() {
+		return runCommand(stdIO, attach...)
+	}
+
+	id := r.localID(config)
+	if !sessionExists(id) {
+		err := runCommand(IO{}, append([]string{"tmux", "new-session", "-d", "-s", id}, attach...)...)
+		if err != nil {
+			return err
+		}
+
+		err = runCommand(IO{}, "tmux", "set-option", "-t", id, pathOption, r.String())
+		if err != nil {
+			return err
+		}
+		recordEvent(EventCreated, id, r.String())
+	}
+
+	return switchSession(id)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Kinds of matches between a query and a project name, best first.
+const (
+	matchExact = iota
+	matchPrefix
+	matchSubstring
+	matchFuzzy
+	matchNone
+)
+
+// resolution is a project considered by resolveProject.
+type resolution struct {
+	dir      string
+	name     string
+	match    int
+	pinned   bool
+	running  bool
+	frecency float64
+}
+
+// resolveProject maps a command line argument to a project directory. Paths
+// are used as is, while anything else is matched against the names of
+// discovered projects.
+func resolveProject(config Config, arg string) (string, error) {
+	if strings.ContainsRune(arg, '/') || arg == "." || arg == ".." {
+		return filepath.Abs(arg)
+	}
+
+	return matchProject(config, arg)
+}
+
+// matchProject returns the project directory best matching query without
+// user interaction. Project names are matched exactly, by prefix, by
+// substring, and finally fuzzily, ignoring case. Ties between matches of the
+// same kind are broken in favor of pinned projects, then running ones, then
+// by frecency, and finally by name, so that the result is predictable.
+func matchProject(config Config, query string) (string, error) {
+	matches, err := projectMatches(config, query)
+	if err != nil {
+		return "", err
+	} else if len(matches) == 0 {
+		return "", fmt.Errorf("tsm: no project matches %q", query)
+	}
+
+	return matches[0].dir, nil
+}
+
+// matchProjectExactly is matchProject for queries that may just as well be
+// mistyped subcommands. Only a project named query, or the only project whose
+// name starts with it, matches.
+func matchProjectExactly(config Config, query string) (string, error) {
+	matches, err := projectMatches(config, query)
+	if err != nil {
+		return "", err
+	}
+
+	// Matches are sorted by kind, so a second prefix match would follow
+	// the first.
+	uniquePrefix := func() bool { return len(matches) == 1 || matches[1].match != matchPrefix }
+	if len(matches) > 0 && (matches[0].match == matchExact || matches[0].match == matchPrefix && uniquePrefix()) {
+		return matches[0].dir, nil
+	}
+
+	return "", fmt.Errorf("tsm: unknown command or project %q; run tsm -h for the commands, or tsm to pick a project", query)
+}
+
+// projectMatches returns the projects matching query, best first.
+func projectMatches(config Config, query string) ([]resolution, error) {
+	dirs, err := listDirectories(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Frecency only orders the matches, so a missing state is no error.
+	state, _ := loadState()
+
+	seen := pathSet{}
+	for _, dir := range dirs {
+		seen.add(dir)
+	}
+	for _, p := range state.Pins {
+		if _, ok := parseRemoteSession(p); !ok && seen.add(p) {
+			dirs = append(dirs, p)
+		}
+	}
+
+	running := map[string]bool{}
+	if sessions, err := listSessionDetails(); err == nil {
+		for _, s := range sessions {
+			running[path.Clean(s.Path)] = true
+		}
+	}
+
+	frecency := historyFrecency(state.History, time.Now())
+
+	var matches []resolution
+	for _, dir := range dirs {
+		name := sessionID(config, dir)
+		match := min(matchName(query, name), matchName(query, path.Base(dir)))
+		if match == matchNone {
+			continue
+		}
+
+		matches = append(matches, resolution{
+			dir:      dir,
+			name:     name,
+			match:    match,
+			pinned:   slices.Contains(state.Pins, dir),
+			running:  running[path.Clean(dir)],
+			frecency: frecency[dir],
+		})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		switch {
+		case a.match != b.match:
+			return a.match < b.match
+		case a.pinned != b.pinned:
+			return a.pinned
+		case a.running != b.running:
+			return a.running
+		case a.frecency != b.frecency:
+			return a.frecency > b.frecency
+		case a.name != b.name:
+			return a.name < b.name
+		default:
+			return a.dir < b.dir
+		}
+	})
+
+	return matches, nil
+}
+
+// matchName returns how query matches name.
+func matchName(query, name string) int {
+	query, name = strings.ToLower(query), strings.ToLower(name)
+
+	switch {
+	case name == query:
+		return matchExact
+	case strings.HasPrefix(name, query):
+		return matchPrefix
+	case strings.Contains(name, query):
+		return matchSubstring
+	}
+
+	// Fuzzy matches contain the query's characters in order.
+	rest := name
+	for _, r := range query {
+		i := strings.IndexRune(rest, r)
+		if i < 0 {
+			return matchNone
+		}
+		rest = rest[i+len(string(r)):]
+	}
+
+	return matchFuzzy
+}
+
+// historyFrecency scores project directories by how often and how recently
+// they were switched to.
+func historyFrecency(history []HistoryEntry, now time.Time) map[string]float64 {
+	scores := map[string]float64{}
+	for _, entry := range history {
+		age := now.Sub(entry.Time)
+		switch {
+		case age < time.Hour:
+			scores[entry.Path] += 4
+		case age < 24*time.Hour:
+			scores[entry.Path] += 2
+		case age < 7*24*time.Hour:
+			scores[entry.Path] += 1
+		default:
+			scores[entry.Path] += 0.25
+		}
+	}
+
+	return scores
+}
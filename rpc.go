@@ -0,0 +1,359 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/signal"
+	"path"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RPCService exposes tsm's operations over JSON-RPC. Methods are available as
+// "TSM.<Method>" to clients connected to the control socket.
+type RPCService struct {
+	mu      sync.RWMutex
+	config  Config
+	metrics serverMetrics
+}
+
+type SessionArgs struct {
+	// Name selects an existing session.
+	Name string `json:"name"`
+	// Path selects a project directory. The session is created if it does
+	// not exist. Path takes precedence over Name.
+	Path string `json:"path"`
+	// OnConflict overrides the configured conflict policy. Interactive
+	// prompts are not possible over RPC, so the fail policy is used if
+	// neither this nor the config specify a non-prompting policy.
+	OnConflict string `json:"on_conflict"`
+	// Client is the tmux client to switch. The most recently used client is
+	// switched if omitted.
+	Client string `json:"client"`
+	// Force allows killing a locked session.
+	Force bool `json:"force"`
+}
+
+func (s *RPCService) ListProjects(_ struct{}, reply *[]Project) error {
+	start := time.Now()
+	projects, err := listProjects(s.currentConfig())
+	if err != nil {
+		return err
+	}
+	s.metrics.observeScan(time.Since(start), len(projects))
+
+	*reply = projects
+	return nil
+}
+
+func (s *RPCService) ListSessions(_ struct{}, reply *[]Session) error {
+	sessions, err := listSessionDetails()
+	if err != nil {
+		return err
+	}
+
+	*reply = sessions
+	return nil
+}
+
+func (s *RPCService) Create(args SessionArgs, reply *Session) error {
+	if args.Path == "" {
+		return errors.New("tsm: path is required")
+	}
+
+	id, err := s.ensureSession(args)
+	if err != nil {
+		return err
+	}
+
+	return s.describe(id, reply)
+}
+
+func (s *RPCService) Switch(args SessionArgs, reply *Session) error {
+	id := args.Name
+	if args.Path != "" {
+		var err error
+		id, err = s.ensureSession(args)
+		if err != nil {
+			return err
+		}
+	}
+
+	if id == "" || !sessionExists(id) {
+		return fmt.Errorf("tsm: session %q does not exist", id)
+	}
+
+	if sessionDir, err := sessionPath(id); err == nil {
+		_ = recordSwitch(id, sessionDir)
+		_ = fetchProject(s.currentConfig(), sessionDir)
+	}
+
+	command := []string{"tmux", "switch-client", "-t", id}
+	if args.Client != "" {
+		command = append(command, "-c", args.Client)
+	}
+
+	err := runCommand(IO{}, command...)
+	if err != nil {
+		return err
+	}
+	s.metrics.switches.Add(1)
+	followMirrors(id)
+
+	return s.describe(id, reply)
+}
+
+func (s *RPCService) Kill(args SessionArgs, reply *Session) error {
+	if args.Name == "" || !sessionExists(args.Name) {
+		return fmt.Errorf("tsm: session %q does not exist", args.Name)
+	}
+
+	if sessionLocked(args.Name) && !args.Force {
+		return fmt.Errorf("tsm: session %q is locked", args.Name)
+	}
+
+	err := s.describe(args.Name, reply)
+	if err != nil {
+		return err
+	}
+
+	err = trashSession(s.currentConfig(), args.Name)
+	if err != nil {
+		return err
+	}
+	s.metrics.kills.Add(1)
+
+	return nil
+}
+
+// ensureSession ensures the session of args.Path, counting it if it had to
+// be created.
+func (s *RPCService) ensureSession(args SessionArgs) (string, error) {
+	_, existed := findSessionForPath(args.Path)
+
+	id, err := ensureSession(s.requestConfig(args), args.Path)
+	if err != nil {
+		return "", err
+	}
+
+	if _, exists := findSessionForPath(args.Path); exists && !existed {
+		s.metrics.sessionsCreated.Add(1)
+	}
+
+	return id, nil
+}
+
+// currentConfig returns the config in effect, which changes when the config
+// file is reloaded.
+func (s *RPCService) currentConfig() Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.config
+}
+
+// watchConfig polls the config file and the files it includes, replacing
+// the config in effect whenever they change. Invalid configs are logged and
+// the previous config stays in effect.
+func (s *RPCService) watchConfig(configPath string) {
+	// Flags may have overridden the config in effect, so changes are
+	// detected against the file's contents.
+	loaded, _, _ := loadConfig(configPath)
+	var lastErr string
+
+	for range time.Tick(configPollInterval) {
+		config, warnings, err := loadConfig(configPath)
+		if err != nil {
+			if err.Error() != lastErr {
+				log.Printf("%v (keeping the previous config)", err)
+				lastErr = err.Error()
+			}
+			continue
+		}
+		lastErr = ""
+
+		if reflect.DeepEqual(config, loaded) {
+			continue
+		}
+		loaded = config
+
+		s.mu.Lock()
+		s.config = config
+		s.mu.Unlock()
+
+		log.Printf("tsm: reloaded config from %s", configPath)
+		for _, warning := range warnings {
+			log.Printf("tsm: config: %s", warning)
+		}
+	}
+}
+
+// autosave saves the layouts of the running sessions every autosave interval
+// of the config in effect, picking up changes to the interval when the config
+// is reloaded.
+func (s *RPCService) autosave() {
+	var lastErr string
+	for {
+		interval, err := autosaveInterval(s.currentConfig())
+		if err != nil {
+			// An invalid interval is logged once rather than on every poll.
+			if err.Error() != lastErr {
+				log.Printf("%v (not saving)", err)
+				lastErr = err.Error()
+			}
+			time.Sleep(configPollInterval)
+			continue
+		}
+		lastErr = ""
+
+		if interval <= 0 {
+			time.Sleep(configPollInterval)
+			continue
+		}
+
+		time.Sleep(interval)
+		err = autosaveSessions()
+		if err != nil {
+			log.Printf("tsm: autosave: %v", err)
+		}
+	}
+}
+
+// autosaveInterval returns the interval of autosave, which is 0 if it is
+// off.
+func autosaveInterval(config Config) (time.Duration, error) {
+	if config.Autosave == "" {
+		return 0, nil
+	}
+
+	interval, err := time.ParseDuration(config.Autosave)
+	if err != nil {
+		return 0, fmt.Errorf("tsm: invalid autosave: %w", err)
+	}
+
+	return interval, nil
+}
+
+// requestConfig returns the config that a request is handled with. Nobody
+// is there to answer a prompt, so conflicts fail unless the request or the
+// config says otherwise, and templates are never picked interactively.
+func (s *RPCService) requestConfig(args SessionArgs) Config {
+	config := s.currentConfig()
+	config.nonInteractive = true
+	if args.OnConflict != "" {
+		config.OnConflict = args.OnConflict
+	}
+
+	if config.OnConflict == "" || config.OnConflict == ConflictPrompt {
+		config.OnConflict = ConflictFail
+	}
+
+	return config
+}
+
+func (s *RPCService) describe(id string, reply *Session) error {
+	sessionDir, err := sessionPath(id)
+	if err != nil {
+		return err
+	}
+
+	*reply = Session{Name: id, Path: sessionDir}
+	return nil
+}
+
+func defaultSocketPath() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = path.Join(os.TempDir(), fmt.Sprintf("tsm-%d", os.Getuid()))
+	}
+
+	return path.Join(dir, "tsm.sock")
+}
+
+// configPollInterval is how often the server checks the config for changes.
+const configPollInterval = 2 * time.Second
+
+func handleServe(configPath string, config Config, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	socketPath := flags.String("socket", defaultSocketPath(), "")
+	metricsAddr := flags.String("metrics", "", "")
+	flags.Parse(args)
+
+	err := os.MkdirAll(path.Dir(*socketPath), 0700)
+	if err != nil {
+		return err
+	}
+
+	// A socket left behind by a previous server prevents listening.
+	if conn, err := net.Dial("unix", *socketPath); err == nil {
+		conn.Close()
+		return fmt.Errorf("tsm: server already listening on %s", *socketPath)
+	}
+	os.Remove(*socketPath)
+
+	_, err = autosaveInterval(config)
+	if err != nil {
+		return err
+	}
+
+	service := &RPCService{config: config}
+	server := rpc.NewServer()
+	err = server.RegisterName("TSM", service)
+	if err != nil {
+		return err
+	}
+
+	go service.watchConfig(configPath)
+	go service.autosave()
+
+	if *metricsAddr != "" {
+		metricsListener, err := net.Listen("tcp", *metricsAddr)
+		if err != nil {
+			return fmt.Errorf("tsm: serving metrics: %w", err)
+		}
+		defer metricsListener.Close()
+
+		handler := http.NewServeMux()
+		handler.Handle("/metrics", &service.metrics)
+		go func() {
+			// The control API keeps working if the metrics endpoint fails.
+			err := http.Serve(metricsListener, handler)
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("tsm: serving metrics: %v", err)
+			}
+		}()
+	}
+
+	listener, err := net.Listen("unix", *socketPath)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// handleRunTemplate applies a template to an existing session: the current
+// one, or the session of a project directory. The session is created from
+// the template if the project has none.
+func handleRunTemplate(config Config, args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New("tsm: run-template requires a template and optionally a project")
+	}
+
+	t, err := lookupTemplate(config, args[0])
+	if err != nil {
+		return err
+	}
+
+	var id, targetDir string
+	if len(args) == 2 {
+		targetDir, err = resolveProject(config, args[1])
+		if err != nil {
+			return err
+		}
+
+		var ok bool
+		id, ok = sessionForTarget(config, targetDir)
+		if !ok {
+			id = sessionID(config, targetDir)
+			if sessionExists(id) {
+				id = nextFreeID(id)
+			}
+
+			err = createTemplateSession(config, id, targetDir, t)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(stdIO.Stdout, "Created session %q\n", id)
+			return nil
+		}
+	} else {
+		id, err = currentSession()
+		if err != nil {
+			return err
+		}
+
+		targetDir, err = sessionPath(id)
+		if err != nil {
+			return err
+		}
+	}
+
+	if t.WindowNaming == "" {
+		t.WindowNaming = config.WindowNaming
+	}
+
+	added, err := addTemplateWindows(id, targetDir, t)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdIO.Stdout, "Added %s to session %q\n", plural(added, "window", "windows"), id)
+
+	return nil
+}
+
+// addTemplateWindows creates the windows of a template that a session does
+// not have yet and returns how many were created. Windows are matched by
+// name, or by the program of their command if they have no name, so applying
+// a template again adds nothing.
+func addTemplateWindows(id, targetDir string, t Template) (int, error) {
+	out, err := runCommandOutput("tmux", "list-windows", "-t", id, "-F", "#{window_name}")
+	if err != nil {
+		return 0, err
+	}
+	names := splitLines(out)
+
+	var windows []WindowTemplate
+	var windowIDs []string
+	for _, w := range t.Windows {
+		name := w.Name
+		if name == "" {
+			name = commandProgram(w.Command)
+		}
+		if name != "" && slices.Contains(names, name) {
+			continue
+		}
+
+		windowID, err := newTemplateWindow(id, targetDir, w)
+		if err != nil {
+			return 0, err
+		}
+
+		windows = append(windows, w)
+		windowIDs = append(windowIDs, windowID)
+		names = append(names, name)
+	}
+
+	t.Windows = windows
+	return len(windows), startWindows(targetDir, t, windowIDs)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"unicode"
+)
+
+// Strategies for handling characters not allowed in session names.
+const (
+	// SanitizeReplace replaces each disallowed character.
+	SanitizeReplace = "replace"
+	// SanitizeStrip removes disallowed characters.
+	SanitizeStrip = "strip"
+	// SanitizeTransliterate spells accented and other Latin letters in
+	// ASCII, e.g. "é" as "e", and replaces any remaining disallowed
+	// characters.
+	SanitizeTransliterate = "transliterate"
+	// SanitizeUnicode keeps letters and digits of every script, which tmux
+	// accepts in names, and replaces any other disallowed characters.
+	SanitizeUnicode = "unicode"
+)
+
+// defaultReplacement is used for disallowed characters unless another
+// replacement is configured.
+const defaultReplacement = "_"
+
+// defaultMaxLength is the length at which session names are cut unless
+// max_length is set.
+const defaultMaxLength = 64
+
+// SanitizeConfig controls how session names are derived from arbitrary
+// strings such as directory names.
+type SanitizeConfig struct {
+	// Mode is one of the Sanitize constants. It defaults to
+	// SanitizeReplace.
+	Mode string `json:"mode,omitempty"`
+	// Replacement replaces disallowed characters. It defaults to "_" and
+	// may only consist of allowed characters, so that tmux's target
+	// separators "." and ":" never end up in a name.
+	Replacement *string `json:"replacement,omitempty"`
+	// Lowercase folds names to lower case.
+	Lowercase bool `json:"lowercase,omitempty"`
+	// MaxLength cuts longer names, ending them with a hash of the full
+	// name so that they stay apart. It defaults to defaultMaxLength, and a
+	// negative length keeps names whole.
+	MaxLength int `json:"max_length,omitempty"`
+}
+
+// transliterations spells common non-ASCII Latin letters in ASCII.
+var transliterations = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'æ': "ae",
+	'ç': "c", 'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ì': "i", 'í': "i",
+	'î': "i", 'ï': "i", 'ð': "d", 'ñ': "n", 'ò': "o", 'ó': "o", 'ô': "o",
+	'õ': "o", 'ö': "o", 'ø': "o", 'ù': "u", 'ú': "u", 'û': "u", 'ü': "u",
+	'ý': "y", 'ÿ': "y", 'þ': "th", 'ß': "ss", 'ł': "l", 'œ': "oe",
+	'À': "A", 'Á': "A", 'Â': "A", 'Ã': "A", 'Ä': "A", 'Å': "A", 'Æ': "AE",
+	'Ç': "C", 'È': "E", 'É': "E", 'Ê': "E", 'Ë': "E", 'Ì': "I", 'Í': "I",
+	'Î': "I", 'Ï': "I", 'Ð': "D", 'Ñ': "N", 'Ò': "O", 'Ó': "O", 'Ô': "O",
+	'Õ': "O", 'Ö': "O", 'Ø': "O", 'Ù': "U", 'Ú': "U", 'Û': "U", 'Ü': "U",
+	'Ý': "Y", 'Þ': "TH", 'Ł': "L", 'Œ': "OE",
+}
+
+// clean turns id into a valid session name.
+func (s SanitizeConfig) clean(id string) string {
+	replacement := defaultReplacement
+	if s.Replacement != nil && strings.IndexFunc(*s.Replacement, func(r rune) bool { return !characterAllowed(r) }) < 0 {
+		replacement = *s.Replacement
+	}
+	if s.Mode == SanitizeStrip {
+		replacement = ""
+	}
+
+	var b strings.Builder
+	lost := false
+	for _, r := range id {
+		if characterAllowed(r) || (s.Mode == SanitizeUnicode && (unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r))) {
+			b.WriteRune(r)
+		} else if t, ok := transliterations[r]; ok && s.Mode == SanitizeTransliterate {
+			b.WriteString(t)
+		} else {
+			b.WriteString(replacement)
+			lost = lost || r > unicode.MaxASCII
+		}
+	}
+
+	name := b.String()
+	if s.Lowercase {
+		name = strings.ToLower(name)
+	}
+
+	// Names in other scripts would otherwise all turn into the same run of
+	// replacements, so a hash of the original tells them apart.
+	if lost {
+		name = fmt.Sprintf("%s-%08x", name, nameHash(id))
+	}
+
+	// Names of nothing but stripped characters still need a name.
+	if name == "" {
+		name = fmt.Sprintf("session-%08x", nameHash(id))
+	}
+
+	maxLength := s.MaxLength
+	if maxLength == 0 {
+		maxLength = defaultMaxLength
+	}
+	if runes := []rune(name); maxLength > 0 && len(runes) > maxLength {
+		if keep := maxLength - 9; keep > 0 {
+			name = fmt.Sprintf("%s-%08x", string(runes[:keep]), nameHash(id))
+		} else {
+			name = string(runes[:maxLength])
+		}
+	}
+
+	return name
+}
+
+func nameHash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// checkSessionName rejects names that tmux would refuse or change, so that
+// no session is created under a name other than the one tsm knows it by.
+func checkSessionName(id string) error {
+	if id == "" {
+		return errors.New("tsm: session names cannot be empty")
+	} else if strings.ContainsAny(id, ".:") {
+		return fmt.Errorf("tsm: session name %q contains \".\" or \":\", which tmux does not allow", id)
+	} else if strings.ContainsRune(id, '/') {
+		// Session names become file names, e.g. of saved layouts.
+		return fmt.Errorf("tsm: session name %q contains \"/\"", id)
+	}
+
+	return nil
+}
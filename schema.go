@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// schemaKey is the top level key pointing editors at the config's JSON
+// Schema. It is not a setting and is ignored.
+const schemaKey = "$schema"
+
+// handleConfig runs the config subcommands.
+func handleConfig(args []string) error {
+	if len(args) == 0 || args[0] != "schema" {
+		return errors.New("tsm: config requires a subcommand: schema")
+	}
+
+	d, err := json.MarshalIndent(configSchema(), "", "    ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(stdIO.Stdout, "%s\n", d)
+	return err
+}
+
+// configSchema returns a JSON Schema describing the config file.
+func configSchema() map[string]any {
+	schema := typeSchema(reflect.TypeOf(Config{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "tsm config"
+	properties := schema["properties"].(map[string]any)
+	properties[schemaKey] = map[string]any{"type": "string"}
+	// Host overrides are partial configs.
+	properties[hostsKey] = map[string]any{"type": "object", "additionalProperties": map[string]any{"$ref": "#"}}
+
+	return schema
+}
+
+func typeSchema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]any{}
+		for name, field := range jsonFields(t) {
+			properties[name] = typeSchema(field)
+		}
+
+		return map[string]any{"type": "object", "properties": properties, "additionalProperties": false}
+	default:
+		return map[string]any{}
+	}
+}
+
+// jsonFields returns the types of a struct's fields by their JSON names.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		} else if name == "" {
+			name = field.Name
+		}
+
+		fields[name] = field.Type
+	}
+
+	return fields
+}
+
+// validateConfig checks a config document against the Config type. Values of
+// the wrong type are errors naming their location, e.g. "base_dirs[2] must
+// be a string", while unknown keys are returned as warnings since they may
+// belong to a newer version of tsm.
+func validateConfig(doc map[string]any) ([]string, error) {
+	var warnings, problems []string
+	delete(doc, schemaKey)
+
+	config := reflect.TypeOf(Config{})
+	if hosts, ok := doc[hostsKey]; ok {
+		validateValue(hostsKey, hosts, reflect.MapOf(reflect.TypeOf(""), config), &warnings, &problems)
+	}
+
+	others := map[string]any{}
+	for k, v := range doc {
+		if k != hostsKey {
+			others[k] = v
+		}
+	}
+	validateValue("", others, config, &warnings, &problems)
+	if len(problems) > 0 {
+		return warnings, errors.New(strings.Join(problems, "; "))
+	}
+
+	return warnings, nil
+}
+
+func validateValue(location string, value any, t reflect.Type, warnings, problems *[]string) {
+	// Null leaves any setting at its default.
+	if value == nil {
+		return
+	}
+
+	fail := func(expected string) {
+		*problems = append(*problems, fmt.Sprintf("%s must be %s", location, expected))
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		validateValue(location, value, t.Elem(), warnings, problems)
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			fail("a string")
+		}
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			fail("a boolean")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n, ok := value.(float64); !ok || n != float64(int64(n)) {
+			fail("an integer")
+		}
+	case reflect.Float32, reflect.Float64:
+		if _, ok := value.(float64); !ok {
+			fail("a number")
+		}
+	case reflect.Slice:
+		items, ok := value.([]any)
+		if !ok {
+			fail("a list")
+			return
+		}
+
+		for i, item := range items {
+			validateValue(fmt.Sprintf("%s[%d]", location, i), item, t.Elem(), warnings, problems)
+		}
+	case reflect.Map:
+		entries, ok := value.(map[string]any)
+		if !ok {
+			fail("an object")
+			return
+		}
+
+		for _, key := range sortedKeys(entries) {
+			validateValue(joinLocation(location, key), entries[key], t.Elem(), warnings, problems)
+		}
+	case reflect.Struct:
+		entries, ok := value.(map[string]any)
+		if !ok {
+			fail("an object")
+			return
+		}
+
+		fields := jsonFields(t)
+		for _, key := range sortedKeys(entries) {
+			field, ok := fields[key]
+			if !ok {
+				*warnings = append(*warnings, fmt.Sprintf("unknown key %s", joinLocation(location, key)))
+				continue
+			}
+
+			validateValue(joinLocation(location, key), entries[key], field, warnings, problems)
+		}
+	}
+}
+
+func joinLocation(location, key string) string {
+	if location == "" {
+		return key
+	}
+
+	return location + "." + key
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
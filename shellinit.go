@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Behaviors of the auto-switch shell hook.
+const (
+	AutoSwitchOff    = "off"
+	AutoSwitchPrompt = "prompt"
+	AutoSwitchOn     = "switch"
+)
+
+const zshHook = `_tsm_auto_switch() {
+  [[ -n "$TMUX" ]] && command tsm auto-switch "$PWD"
+}
+autoload -Uz add-zsh-hook
+add-zsh-hook chpwd _tsm_auto_switch
+`
+
+const bashHook = `_tsm_auto_switch() {
+  if [[ -n "$TMUX" && "$PWD" != "$_TSM_LAST_PWD" ]]; then
+    _TSM_LAST_PWD="$PWD"
+    command tsm auto-switch "$PWD"
+  fi
+}
+_TSM_LAST_PWD="$PWD"
+PROMPT_COMMAND="_tsm_auto_switch${PROMPT_COMMAND:+;$PROMPT_COMMAND}"
+`
+
+const fishHook = `function _tsm_auto_switch --on-variable PWD
+  set -q TMUX; and command tsm auto-switch "$PWD"
+end
+`
+
+func handleShellInit(args []string) error {
+	if len(args) != 1 {
+		return errors.New("tsm: shell-init requires a shell (zsh, bash, or fish)")
+	}
+
+	switch args[0] {
+	case "zsh":
+		fmt.Fprint(stdIO.Stdout, zshHook)
+	case "bash":
+		fmt.Fprint(stdIO.Stdout, bashHook)
+	case "fish":
+		fmt.Fprint(stdIO.Stdout, fishHook)
+	default:
+		return fmt.Errorf("tsm: unsupported shell %q", args[0])
+	}
+
+	return nil
+}
+
+// handleAutoSwitch is called by the shell hook whenever the working directory
+// changes. If the directory belongs to a project other than the one backing
+// the current session, the user is offered a switch to that project's
+// session, or switched automatically depending on the auto_switch setting.
+func handleAutoSwitch(config Config, args []string) error {
+	if len(args) != 1 || !insideTmux() || config.AutoSwitch == AutoSwitchOff {
+		return nil
+	}
+
+	projectDir, ok := projectForPath(config, args[0])
+	if !ok {
+		return nil
+	}
+
+	current, err := currentSession()
+	if err != nil {
+		return err
+	}
+
+	currentDir, err := sessionPath(current)
+	if err != nil {
+		return err
+	}
+
+	if path.Clean(currentDir) == projectDir {
+		return nil
+	}
+
+	if config.AutoSwitch != AutoSwitchOn {
+		ok, err := confirm("Switch to the session for %s?", projectDir)
+		if err != nil || !ok {
+			return err
+		}
+	}
+
+	id, err := ensureSession(config, projectDir)
+	if err != nil || id == "" {
+		return err
+	}
+
+	return switchToSession(config, id)
+}
+
+// projectForPath returns the project directory containing dir, i.e. a
+// registered project or the direct child of a base dir that dir is in or
+// below.
+func projectForPath(config Config, dir string) (string, bool) {
+	dir = path.Clean(dir)
+
+	for _, p := range config.Projects {
+		projectDir := path.Clean(expandHome(p.Path))
+		if dir == projectDir || strings.HasPrefix(dir, projectDir+"/") {
+			return projectDir, true
+		}
+	}
+
+	for _, baseDir := range config.BaseDirs {
+		baseDir = path.Clean(expandHome(baseDir))
+
+		rel, ok := strings.CutPrefix(dir, baseDir+"/")
+		if !ok || rel == "" {
+			continue
+		}
+
+		projectDir := path.Join(baseDir, strings.Split(rel, "/")[0])
+		if isIgnored(projectDir, config) {
+			continue
+		}
+
+		return projectDir, true
+	}
+
+	return "", false
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Snapshot describes the layout of a tmux session so that it can be
+// recreated after the tmux server has exited.
+type Snapshot struct {
+	Name    string           `json:"name"`
+	Path    string           `json:"path"`
+	Windows []WindowSnapshot `json:"windows"`
+	// Env names environment variables copied from tsm's environment into
+	// the session when it is restored.
+	Env []string `json:"env,omitempty"`
+	// Autosaved marks snapshots taken by autosave rather than save, which
+	// are dropped once their session is killed.
+	Autosaved bool `json:"autosaved,omitempty"`
+}
+
+type WindowSnapshot struct {
+	Index  int            `json:"index"`
+	Name   string         `json:"name"`
+	Layout string         `json:"layout"`
+	Active bool           `json:"active"`
+	Panes  []PaneSnapshot `json:"panes"`
+}
+
+type PaneSnapshot struct {
+	Index  int    `json:"index"`
+	Path   string `json:"path"`
+	Active bool   `json:"active"`
+	// Command is run in the pane when it is restored. Saved snapshots do
+	// not record running programs, but exported sessions do.
+	Command string `json:"command,omitempty"`
+}
+
+func getSnapshotsPath() (string, error) {
+	return getStatePath("snapshots.json")
+}
+
+func readSnapshots(snapshotsPath string) (map[string]Snapshot, error) {
+	f, err := os.ReadFile(snapshotsPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]Snapshot{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	snapshots := map[string]Snapshot{}
+	err = json.Unmarshal(f, &snapshots)
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshots, nil
+}
+
+func writeSnapshots(snapshotsPath string, snapshots map[string]Snapshot) error {
+	d, err := json.Marshal(snapshots)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(snapshotsPath, d, 0600)
+}
+
+// updateSnapshots applies update to the saved snapshots while holding off
+// other updates.
+func updateSnapshots(update func(map[string]Snapshot) error) error {
+	snapshotsPath, err := getSnapshotsPath()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(snapshotsPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	snapshots, err := readSnapshots(snapshotsPath)
+	if err != nil {
+		return err
+	}
+
+	err = update(snapshots)
+	if err != nil {
+		return err
+	}
+
+	return writeSnapshots(snapshotsPath, snapshots)
+}
+
+func handleSave(ids []string) error {
+	return updateSnapshots(func(snapshots map[string]Snapshot) error {
+		var err error
+		if len(ids) == 0 {
+			ids, err = listSessions()
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, id := range ids {
+			snapshot, err := takeSnapshot(id)
+			if err != nil {
+				return err
+			}
+
+			snapshots[id] = snapshot
+		}
+
+		return nil
+	})
+}
+
+// autosaveSessions saves the layout of every running session, so that they
+// can be restored after the tmux server exits. Autosaved snapshots of
+// sessions killed since are dropped, while those taken with save are neither
+// replaced nor dropped. Nothing changes while no tmux server is running,
+// keeping the layouts saved last for restore.
+func autosaveSessions() error {
+	ids, err := listSessions()
+	if err != nil {
+		return nil
+	}
+
+	return updateSnapshots(func(snapshots map[string]Snapshot) error {
+		for id, snapshot := range snapshots {
+			if snapshot.Autosaved && !slices.Contains(ids, id) {
+				delete(snapshots, id)
+			}
+		}
+
+		for _, id := range ids {
+			// Snapshots taken with save are only replaced by save.
+			if snapshot, ok := snapshots[id]; ok && !snapshot.Autosaved {
+				continue
+			}
+
+			snapshot, err := takeSnapshot(id)
+			if err != nil {
+				// The session was killed in the meantime.
+				continue
+			}
+
+			snapshot.Autosaved = true
+			snapshots[id] = snapshot
+		}
+
+		return nil
+	})
+}
+
+func handleRestore(ids []string) error {
+	snapshotsPath, err := getSnapshotsPath()
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := readSnapshots(snapshotsPath)
+	if err != nil {
+		return err
+	}
+
+	if len(ids) == 0 {
+		for id := range snapshots {
+			ids = append(ids, id)
+		}
+		slices.Sort(ids)
+	}
+
+	for _, id := range ids {
+		snapshot, ok := snapshots[id]
+		if !ok {
+			return fmt.Errorf("tsm: no snapshot for session %q", id)
+		}
+
+		if sessionExists(id) {
+			continue
+		}
+
+		err = restoreSnapshot(snapshot)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func takeSnapshot(id string) (Snapshot, error) {
+	snapshot := Snapshot{Name: id}
+
+	sessionDir, err := sessionPath(id)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	snapshot.Path = sessionDir
+
+	windows, err := runCommandOutput("tmux", "list-windows", "-t", id,
+		"-F", tmuxFormat("#{window_index}", "#{window_name}", "#{window_layout}", "#{window_active}"))
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	for _, line := range splitLines(windows) {
+		fields := strings.Split(line, fieldSep)
+		if len(fields) != 4 {
+			continue
+		}
+
+		index, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return Snapshot{}, err
+		}
+
+		snapshot.Windows = append(snapshot.Windows, WindowSnapshot{
+			Index:  index,
+			Name:   fields[1],
+			Layout: fields[2],
+			Active: fields[3] == "1",
+		})
+	}
+
+	panes, err := runCommandOutput("tmux", "list-panes", "-s", "-t", id,
+		"-F", tmuxFormat("#{window_index}", "#{pane_index}", "#{pane_current_path}", "#{pane_active}"))
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	for _, line := range splitLines(panes) {
+		fields := strings.Split(line, fieldSep)
+		if len(fields) != 4 {
+			continue
+		}
+
+		windowIndex, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return Snapshot{}, err
+		}
+
+		paneIndex, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return Snapshot{}, err
+		}
+
+		for i := range snapshot.Windows {
+			if snapshot.Windows[i].Index != windowIndex {
+				continue
+			}
+
+			snapshot.Windows[i].Panes = append(snapshot.Windows[i].Panes, PaneSnapshot{
+				Index:  paneIndex,
+				Path:   fields[2],
+				Active: fields[3] == "1",
+			})
+		}
+	}
+
+	return snapshot, nil
+}
+
+// restoreSnapshot recreates a detached session from a snapshot. Focus is
+// returned to the window and pane that were active when the snapshot was
+// taken.
+func restoreSnapshot(snapshot Snapshot) error {
+	// Snapshots may have been edited or shared since they were taken.
+	err := checkSessionName(snapshot.Name)
+	if err != nil {
+		return err
+	}
+
+	env := map[string]string{}
+	for _, name := range snapshot.Env {
+		if value, ok := os.LookupEnv(name); ok {
+			env[name] = value
+		}
+	}
+
+	if len(snapshot.Windows) == 0 {
+		return createSession(snapshot.Name, snapshot.Path, "", env, nil, "")
+	}
+
+	var activeWindow, activePane string
+	for i, window := range snapshot.Windows {
+		windowDir := snapshot.Path
+		if len(window.Panes) > 0 {
+			windowDir = window.Panes[0].Path
+		}
+
+		var windowID string
+		var err error
+		if i == 0 {
+			// The session's directory is where tmux opens new windows, so
+			// it is the project directory rather than the first pane's.
+			sessionDir := snapshot.Path
+			if sessionDir == "" {
+				sessionDir = windowDir
+			}
+
+			command := []string{"tmux", "new-session", "-d", "-s", snapshot.Name,
+				"-n", window.Name, "-c", sessionDir, "-P", "-F", "#{window_id}"}
+			windowID, err = runCommandOutput(append(command, envArgs(env)...)...)
+			if err == nil && windowDir != sessionDir {
+				err = runCommand(IO{}, "tmux", "respawn-pane", "-k", "-t", strings.TrimSpace(windowID), "-c", windowDir)
+			}
+		} else {
+			windowID, err = runCommandOutput("tmux", "new-window", "-d", "-t", snapshot.Name+":",
+				"-n", window.Name, "-c", windowDir, "-P", "-F", "#{window_id}")
+		}
+		if err != nil {
+			return err
+		}
+		windowID = strings.TrimSpace(windowID)
+
+		if i == 0 {
+			err = runCommand(IO{}, "tmux", "set-option", "-t", snapshot.Name, pathOption, snapshot.Path)
+			if err != nil {
+				return err
+			}
+			recordEvent(EventCreated, snapshot.Name, snapshot.Path)
+		}
+
+		paneIDs, err := runCommandOutput("tmux", "list-panes", "-t", windowID, "-F", "#{pane_id}")
+		if err != nil {
+			return err
+		}
+		paneID := strings.TrimSpace(paneIDs)
+
+		for j, pane := range window.Panes {
+			if j > 0 {
+				paneID, err = runCommandOutput("tmux", "split-window", "-d", "-t", windowID,
+					"-c", pane.Path, "-P", "-F", "#{pane_id}")
+				if err != nil {
+					return err
+				}
+				paneID = strings.TrimSpace(paneID)
+			}
+
+			if pane.Command != "" {
+				err = runCommand(IO{}, "tmux", "send-keys", "-t", paneID, pane.Command, "Enter")
+				if err != nil {
+					return err
+				}
+			}
+
+			if pane.Active {
+				err = runCommand(IO{}, "tmux", "select-pane", "-t", paneID)
+				if err != nil {
+					return err
+				}
+
+				if window.Active {
+					activePane = paneID
+				}
+			}
+		}
+
+		if window.Layout != "" {
+			err = runCommand(IO{}, "tmux", "select-layout", "-t", windowID, window.Layout)
+			if err != nil {
+				return err
+			}
+		}
+
+		if window.Active {
+			activeWindow = windowID
+		}
+	}
+
+	if activeWindow != "" {
+		err := runCommand(IO{}, "tmux", "select-window", "-t", activeWindow)
+		if err != nil {
+			return err
+		}
+	}
+
+	if activePane != "" {
+		return runCommand(IO{}, "tmux", "select-pane", "-t", activePane)
+	}
+
+	return nil
+}
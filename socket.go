@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"syscall"
+)
+
+// defaultTmuxSocket returns the socket of the default tmux server, which
+// tmux keeps in a directory of the user's own under $TMUX_TMPDIR or /tmp.
+func defaultTmuxSocket() string {
+	dir := os.Getenv("TMUX_TMPDIR")
+	if dir == "" {
+		dir = "/tmp"
+	}
+
+	return path.Join(dir, fmt.Sprintf("tmux-%d", os.Getuid()), "default")
+}
+
+// tmuxError is the failure of a tmux command, after which tsm looks for a
+// problem with the socket.
+type tmuxError struct {
+	err error
+}
+
+func (e tmuxError) Error() string {
+	return e.err.Error()
+}
+
+func (e tmuxError) Unwrap() error {
+	return e.err
+}
+
+// tmuxFailure marks err as a tmux error if the command run was tmux.
+func tmuxFailure(command []string, err error) error {
+	if err == nil || command[0] != "tmux" {
+		return err
+	}
+
+	return tmuxError{err: err}
+}
+
+// tmuxSocketProblem looks for the usual reasons why tmux cannot reach its
+// server, namely running through sudo, a socket directory owned by another
+// user or with permissions tmux refuses, and sockets deleted by a cleanup of
+// the temporary directory or left over from a crashed server. It returns a
+// message with a remedy, or an empty string if nothing is wrong with the
+// socket.
+func tmuxSocketProblem() string {
+	if os.Geteuid() == 0 && os.Getenv("SUDO_USER") != "" {
+		return fmt.Sprintf("tsm: running through sudo reaches root's tmux server rather than that of %s; run tsm without sudo", os.Getenv("SUDO_USER"))
+	}
+
+	socket := tmuxSocket()
+	if socket == "" {
+		socket = defaultTmuxSocket()
+	}
+	dir := path.Dir(socket)
+
+	// A server that is still running recreates its socket on SIGUSR1.
+	gone := fmt.Sprintf("tsm: the tmux socket %s is gone, e.g. removed by a cleanup of the temporary directory; if tmux is still running, run: pkill -USR1 -x tmux", socket)
+
+	info, err := os.Stat(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		if os.Getenv("TMUX") != "" {
+			return gone
+		}
+		return ""
+	} else if err != nil {
+		return fmt.Sprintf("tsm: cannot access the tmux socket directory %s: %v", dir, err)
+	}
+
+	if problem := socketOwnerProblem(dir, info); problem != "" {
+		return problem
+	}
+	if info.Mode().Perm()&0007 != 0 {
+		return fmt.Sprintf("tsm: tmux refuses the socket directory %s since other users can access it; run: chmod 700 %s", dir, shellQuote(dir))
+	}
+
+	info, err = os.Stat(socket)
+	if errors.Is(err, os.ErrNotExist) {
+		if os.Getenv("TMUX") != "" {
+			return gone
+		}
+		return ""
+	} else if err != nil {
+		return fmt.Sprintf("tsm: cannot access the tmux socket %s: %v", socket, err)
+	}
+
+	if problem := socketOwnerProblem(socket, info); problem != "" {
+		return problem
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err == nil {
+		conn.Close()
+		return ""
+	} else if errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Sprintf("tsm: the tmux socket %s is left over from a server that is no longer running; remove it with: rm %s", socket, shellQuote(socket))
+	} else if errors.Is(err, syscall.EACCES) {
+		return fmt.Sprintf("tsm: permission denied on the tmux socket %s; check the permissions of it and %s", socket, dir)
+	}
+
+	return ""
+}
+
+// socketOwnerProblem reports a tmux socket, or its directory, that belongs to
+// another user, as happens after running tmux through sudo.
+func socketOwnerProblem(p string, info os.FileInfo) string {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || int(stat.Uid) == os.Getuid() {
+		return ""
+	}
+
+	return fmt.Sprintf("tsm: %s belongs to another user (uid %d), e.g. after running tmux through sudo; remove it with: sudo rm -r %s", p, stat.Uid, shellQuote(p))
+}
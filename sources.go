@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Sources of project directories other than base dirs.
+const (
+	// SourceGHQ lists the repositories managed by ghq.
+	SourceGHQ = "ghq"
+	// SourceGH lists the open pull requests that request your review or
+	// are assigned to you. They are listed by the picker rather than as
+	// project directories since they are only cloned once selected.
+	SourceGH = "gh"
+)
+
+// Origins of picker entries besides the sources, named for --source and
+// source_weights.
+const (
+	// OriginPins lists pinned entries.
+	OriginPins = "pins"
+	// OriginProjects lists registered projects.
+	OriginProjects = "projects"
+	// OriginScan lists the directories found in base dirs.
+	OriginScan = "scan"
+	// OriginRemotes lists sessions on remote servers.
+	OriginRemotes = "remotes"
+)
+
+// defaultOrigins are the origins of picker entries in the order they are
+// listed unless weighted differently.
+var defaultOrigins = []string{OriginPins, OriginProjects, OriginScan, SourceGHQ, OriginRemotes, SourceGH}
+
+// selectSources restricts the origins walked to those listed in sources,
+// separated by commas, enabling sources that the config does not. Unknown
+// sources in the config are reported.
+func selectSources(config *Config, sources string) error {
+	for _, source := range config.Sources {
+		if source != SourceGHQ && source != SourceGH {
+			return fmt.Errorf("tsm: unknown source %q", source)
+		}
+	}
+
+	for origin := range config.SourceWeights {
+		if !slices.Contains(defaultOrigins, origin) {
+			return fmt.Errorf("tsm: unknown source %q in source_weights; expected one of %s", origin, strings.Join(defaultOrigins, ", "))
+		}
+	}
+
+	if sources == "" {
+		return nil
+	}
+
+	config.onlySources = []string{}
+	for _, origin := range strings.Split(sources, ",") {
+		origin = strings.TrimSpace(origin)
+		if !slices.Contains(defaultOrigins, origin) {
+			return fmt.Errorf("tsm: unknown source %q; expected one of %s", origin, strings.Join(defaultOrigins, ", "))
+		}
+
+		config.onlySources = append(config.onlySources, origin)
+		if (origin == SourceGHQ || origin == SourceGH) && !slices.Contains(config.Sources, origin) {
+			config.Sources = append(config.Sources, origin)
+		}
+	}
+
+	return nil
+}
+
+// origins returns the origins of picker entries to walk, heaviest first.
+func origins(config Config) []string {
+	var enabled []string
+	for _, origin := range defaultOrigins {
+		if config.onlySources != nil && !slices.Contains(config.onlySources, origin) {
+			continue
+		} else if (origin == SourceGHQ || origin == SourceGH) && !slices.Contains(config.Sources, origin) {
+			continue
+		}
+
+		enabled = append(enabled, origin)
+	}
+
+	weight := func(origin string) float64 {
+		if w, ok := config.SourceWeights[origin]; ok {
+			return w
+		}
+		return 1
+	}
+	sort.SliceStable(enabled, func(i, j int) bool { return weight(enabled[i]) > weight(enabled[j]) })
+
+	return enabled
+}
+
+// directoryOrigin reports whether an origin lists project directories, as
+// opposed to other picker entries.
+func directoryOrigin(origin string) bool {
+	return origin == OriginProjects || origin == OriginScan || origin == SourceGHQ
+}
+
+// walkOrigin calls fn for every entry listed by an origin.
+func walkOrigin(config Config, origin string, fn func(string) error) error {
+	// Discovered directories are subject to the ignore rules.
+	discovered := func(p string) error {
+		if isIgnored(p, config) {
+			return nil
+		}
+
+		return fn(p)
+	}
+
+	switch origin {
+	case OriginPins:
+		for _, p := range readPins() {
+			err := fn(p)
+			if err != nil {
+				return err
+			}
+		}
+	case OriginProjects:
+		for _, p := range config.Projects {
+			err := fn(path.Clean(expandHome(p.Path)))
+			if err != nil {
+				return err
+			}
+		}
+	case OriginScan:
+		for _, baseDir := range config.BaseDirs {
+			err := walkBaseDir(config, expandHome(baseDir), discovered)
+			if err != nil {
+				return err
+			}
+		}
+	case SourceGHQ:
+		out, err := runCommandOutput("ghq", "list", "-p")
+		if err != nil {
+			return fmt.Errorf("tsm: listing ghq repositories: %w", err)
+		}
+
+		// Sorted so that the picker does not reorder between runs.
+		dirs := splitLines(out)
+		slices.Sort(dirs)
+		for _, dir := range dirs {
+			err := discovered(path.Clean(dir))
+			if err != nil {
+				return err
+			}
+		}
+	case OriginRemotes:
+		return walkRemoteSessions(config, fn)
+	case SourceGH:
+		return walkPullRequests(config, fn)
+	}
+
+	return nil
+}
+
+// ghqRoots returns the ghq root directories. They are looked up at most once
+// per invocation since session names are derived for every listed project.
+var ghqRoots = sync.OnceValue(func() []string {
+	out, err := runCommandOutput("ghq", "root", "--all")
+	if err != nil {
+		return nil
+	}
+
+	return splitLines(out)
+})
+
+// ghqName returns the name of a repository below a ghq root, its
+// "host/org/repo" path joined with "-", e.g. "github.com-org-repo", or an
+// empty string if dir is not in one. Slashes are kept out of session names,
+// which would otherwise be taken for paths.
+func ghqName(dir string) string {
+	for _, root := range ghqRoots() {
+		if rel, ok := strings.CutPrefix(dir, path.Clean(root)+"/"); ok {
+			return strings.ReplaceAll(rel, "/", "-")
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"time"
+)
+
+var errNothingToResume = errors.New("tsm: no sessions recorded before today")
+
+// runningDays is the number of days of running session records kept in the
+// state file.
+const runningDays = 7
+
+// State is data that tsm records about its usage.
+type State struct {
+	// Running maps a date (YYYY-MM-DD) to the sessions that were last seen
+	// running on that day.
+	Running map[string][]Session `json:"running,omitempty"`
+	// History records every switch made through tsm, oldest first.
+	History []HistoryEntry `json:"history,omitempty"`
+	// HistoryCursor is how many entries of History prev has stepped back
+	// from the latest switch.
+	HistoryCursor int `json:"history_cursor,omitempty"`
+	// Activity records clients attaching to and detaching from sessions.
+	Activity []ActivityEvent `json:"activity,omitempty"`
+	// Pins are picker entries listed before all others.
+	Pins []string `json:"pins,omitempty"`
+	// Archived lists the project directories hidden with archive.
+	Archived []string `json:"archived,omitempty"`
+	// Fetched records when auto_fetch last fetched each repository.
+	Fetched map[string]time.Time `json:"fetched,omitempty"`
+	// Temp lists the scratch directories created by tmp.
+	Temp []TempDir `json:"temp,omitempty"`
+	// ZellijSessions maps the zellij sessions created by tsm to their
+	// project directories.
+	ZellijSessions map[string]string `json:"zellij_sessions,omitempty"`
+}
+
+func getStateFilePath() (string, error) {
+	return getStatePath("state.json")
+}
+
+// errStateUnchanged is returned by a state update to leave the stored state
+// as it is.
+var errStateUnchanged = errors.New("tsm: state unchanged")
+
+// stateStore persists the State. Commands go through a store rather than the
+// state file, so that another backend, e.g. a database for large histories,
+// can replace the JSON file without changing them.
+type stateStore interface {
+	// Load returns the stored state, which is empty if none was stored.
+	Load() (State, error)
+	// Update applies update to the stored state while holding off other
+	// updates. History and Activity are left out of the state passed to
+	// update, so that it does not depend on their size; entries appended to
+	// them are added to the stored ones, of which the latest maxHistory and
+	// maxActivity are kept.
+	Update(update func(*State) error) error
+	// RewriteHistory replaces every stored switch by the one rewrite
+	// returns for it, or removes it if rewrite returns false.
+	RewriteHistory(rewrite func(HistoryEntry) (HistoryEntry, bool)) error
+	// History returns the switches from the last one before after onward,
+	// oldest first, as that one may still be going on at after.
+	History(after time.Time) ([]HistoryEntry, error)
+	// Activity returns the client events from the last one before after
+	// onward, oldest first.
+	Activity(after time.Time) ([]ActivityEvent, error)
+}
+
+// State backends selected with state_backend.
+const (
+	// StateBackendJSON keeps the state in a JSON file.
+	StateBackendJSON = "json"
+	// StateBackendSQLite keeps the state in a SQLite database, which
+	// records switches and reports on long histories faster.
+	StateBackendSQLite = "sqlite"
+)
+
+// stateBackend is the backend selected with state_backend.
+var stateBackend = StateBackendJSON
+
+// selectStateBackend sets stateBackend from the config.
+func selectStateBackend(config Config) error {
+	switch config.StateBackend {
+	case "", StateBackendJSON:
+		stateBackend = StateBackendJSON
+	case StateBackendSQLite:
+		stateBackend = StateBackendSQLite
+	default:
+		return fmt.Errorf("tsm: unknown state_backend %q", config.StateBackend)
+	}
+
+	return nil
+}
+
+// jsonStateStore stores the state in a JSON file guarded by a lock file.
+type jsonStateStore struct {
+	path string
+}
+
+func (s jsonStateStore) Load() (State, error) {
+	return readState(s.path)
+}
+
+func (s jsonStateStore) Update(update func(*State) error) error {
+	unlock, err := lockFile(s.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := readState(s.path)
+	if err != nil {
+		return err
+	}
+
+	history, activity := state.History, state.Activity
+	state.History, state.Activity = nil, nil
+	err = update(&state)
+	if errors.Is(err, errStateUnchanged) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	state.History = lastEntries(append(history, state.History...), maxHistory)
+	state.Activity = lastEntries(append(activity, state.Activity...), maxActivity)
+	return writeState(s.path, state)
+}
+
+func (s jsonStateStore) RewriteHistory(rewrite func(HistoryEntry) (HistoryEntry, bool)) error {
+	unlock, err := lockFile(s.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := readState(s.path)
+	if err != nil {
+		return err
+	}
+
+	history := state.History[:0]
+	for _, entry := range state.History {
+		if entry, ok := rewrite(entry); ok {
+			history = append(history, entry)
+		}
+	}
+	state.History = history
+
+	return writeState(s.path, state)
+}
+
+// lastEntries returns the latest n entries.
+func lastEntries[T any](entries []T, n int) []T {
+	if len(entries) > n {
+		return entries[len(entries)-n:]
+	}
+
+	return entries
+}
+
+func (s jsonStateStore) History(after time.Time) ([]HistoryEntry, error) {
+	state, err := readState(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	return entriesSince(state.History, after, func(e HistoryEntry) time.Time { return e.Time }), nil
+}
+
+func (s jsonStateStore) Activity(after time.Time) ([]ActivityEvent, error) {
+	state, err := readState(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	return entriesSince(state.Activity, after, func(e ActivityEvent) time.Time { return e.Time }), nil
+}
+
+// entriesSince returns the entries from the last one before after onward,
+// like the queries of the SQLite backend.
+func entriesSince[T any](entries []T, after time.Time, timeOf func(T) time.Time) []T {
+	start := time.Time{}
+	for _, e := range entries {
+		if t := timeOf(e); t.Before(after) && t.After(start) {
+			start = t
+		}
+	}
+	if start.IsZero() {
+		start = after
+	}
+
+	since := []T{}
+	for _, e := range entries {
+		if !timeOf(e).Before(start) {
+			since = append(since, e)
+		}
+	}
+
+	return since
+}
+
+func openStateStore() (stateStore, error) {
+	if stateBackend == StateBackendSQLite {
+		db, err := openStateDB()
+		if err != nil {
+			return nil, err
+		}
+
+		return sqliteStateStore{db: db}, nil
+	}
+
+	statePath, err := getStateFilePath()
+	if err != nil {
+		return nil, err
+	}
+
+	return jsonStateStore{path: statePath}, nil
+}
+
+// loadState returns the stored state.
+func loadState() (State, error) {
+	store, err := openStateStore()
+	if err != nil {
+		return State{}, err
+	}
+
+	return store.Load()
+}
+
+func readState(statePath string) (State, error) {
+	f, err := os.ReadFile(statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return State{}, nil
+	} else if err != nil {
+		return State{}, err
+	}
+
+	var state State
+	err = json.Unmarshal(f, &state)
+	if err != nil {
+		return State{}, err
+	}
+
+	return state, nil
+}
+
+func writeState(statePath string, state State) error {
+	d, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(statePath, d, 0600)
+}
+
+// recordRunningSessions stores the currently running sessions under today's
+// date. The state file is only rewritten if the set of sessions changed.
+func recordRunningSessions() error {
+	sessions, err := listSessionDetails()
+	if err != nil {
+		// No tmux server is running, so there is nothing to record.
+		return nil
+	}
+
+	return updateState(func(state *State) error {
+		today := time.Now().Format(time.DateOnly)
+		if slices.Equal(state.Running[today], sessions) {
+			return errStateUnchanged
+		}
+
+		if state.Running == nil {
+			state.Running = map[string][]Session{}
+		}
+		state.Running[today] = sessions
+
+		days := make([]string, 0, len(state.Running))
+		for day := range state.Running {
+			days = append(days, day)
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(days)))
+		for _, day := range days[min(len(days), runningDays):] {
+			delete(state.Running, day)
+		}
+
+		return nil
+	})
+}
+
+// handleResume recreates, without attaching, the sessions that were running
+// at the end of the most recent day before today.
+func handleResume(config Config) error {
+	state, err := loadState()
+	if err != nil {
+		return err
+	}
+
+	today := time.Now().Format(time.DateOnly)
+	var latest string
+	for day := range state.Running {
+		if day < today && day > latest {
+			latest = day
+		}
+	}
+
+	if latest == "" {
+		return errNothingToResume
+	}
+
+	archived := readArchived()
+	for _, s := range state.Running[latest] {
+		if sessionExists(s.Name) || archived.has(s.Path) {
+			continue
+		}
+
+		if _, err := os.Stat(s.Path); err != nil {
+			fmt.Fprintf(stdIO.Stderr, "Skipping %q: %v\n", s.Name, err)
+			continue
+		}
+
+		err = createProjectSession(config, s.Name, s.Path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tmuxServerFresh reports whether no tmux server is running or the running
+// server has no sessions.
+func tmuxServerFresh() bool {
+	sessions, err := listSessions()
+	return err != nil || len(sessions) == 0
+}
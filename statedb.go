@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema keeps history and activity in tables of their own, so that
+// recording a switch inserts a row and reports query a range of them, and
+// the rest of the state as a JSON document in a single row.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS history (
+	id INTEGER PRIMARY KEY,
+	session TEXT NOT NULL,
+	path TEXT NOT NULL,
+	time INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS history_time ON history (time);
+CREATE TABLE IF NOT EXISTS activity (
+	id INTEGER PRIMARY KEY,
+	event TEXT NOT NULL,
+	session TEXT NOT NULL,
+	time INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS activity_time ON activity (time);
+CREATE TABLE IF NOT EXISTS state (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	data TEXT NOT NULL
+);
+`
+
+// sqliteStateStore stores the state in a SQLite database, for histories too
+// large to rewrite as a JSON file on every switch.
+type sqliteStateStore struct {
+	db *sql.DB
+}
+
+// openStateDB opens the state database once per run, creating it from the
+// JSON state file if it does not exist yet.
+var openStateDB = sync.OnceValues(func() (*sql.DB, error) {
+	dbPath, err := getStatePath("state.db")
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite creates the database with the umask, but it can hold the
+	// same paths as the state file.
+	f, err := os.OpenFile(dbPath, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	// Other tsm processes, e.g. the tmux hooks recording activity, may
+	// hold the database briefly. Transactions take the write lock right
+	// away, so that reading and updating the state is not interleaved.
+	db, err := sql.Open("sqlite", "file:"+dbPath+"?_pragma=busy_timeout(5000)&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(sqliteSchema)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	err = importJSONState(sqliteStateStore{db: db})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+})
+
+// importJSONState copies the state file into a new database, which has no
+// state row yet. The state file is left as it was.
+func importJSONState(s sqliteStateStore) error {
+	var rows int
+	err := s.db.QueryRow("SELECT count(*) FROM state").Scan(&rows)
+	if err != nil || rows > 0 {
+		return err
+	}
+
+	statePath, err := getStateFilePath()
+	if err != nil {
+		return err
+	}
+
+	state, err := readState(statePath)
+	if err != nil {
+		return err
+	}
+
+	return s.Update(func(s *State) error {
+		*s = state
+		return nil
+	})
+}
+
+func (s sqliteStateStore) Load() (State, error) {
+	tx, err := s.db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return State{}, err
+	}
+	defer tx.Rollback()
+
+	state, err := loadStateDocument(tx)
+	if err != nil {
+		return State{}, err
+	}
+
+	_, state.History, err = historyTable.selectAll(tx)
+	if err != nil {
+		return State{}, err
+	}
+
+	_, state.Activity, err = activityTable.selectAll(tx)
+	if err != nil {
+		return State{}, err
+	}
+
+	return state, nil
+}
+
+// Update only reads and writes the document of the state. The entries
+// appended to History and Activity are inserted as rows, and the rows beyond
+// maxHistory and maxActivity deleted.
+func (s sqliteStateStore) Update(update func(*State) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	state, err := loadStateDocument(tx)
+	if err != nil {
+		return err
+	}
+
+	err = update(&state)
+	if errors.Is(err, errStateUnchanged) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	err = historyTable.append(tx, state.History, maxHistory)
+	if err != nil {
+		return err
+	}
+
+	err = activityTable.append(tx, state.Activity, maxActivity)
+	if err != nil {
+		return err
+	}
+
+	state.History = nil
+	state.Activity = nil
+	d, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT OR REPLACE INTO state (id, data) VALUES (1, ?)", string(d))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s sqliteStateStore) RewriteHistory(rewrite func(HistoryEntry) (HistoryEntry, bool)) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ids, entries, err := historyTable.selectAll(tx)
+	if err != nil {
+		return err
+	}
+
+	for i, entry := range entries {
+		rewritten, ok := rewrite(entry)
+		switch {
+		case !ok:
+			_, err = tx.Exec("DELETE FROM history WHERE id = ?", ids[i])
+		case rewritten != entry:
+			_, err = tx.Exec("UPDATE history SET session = ?, path = ?, time = ? WHERE id = ?",
+				append(historyTable.values(rewritten), ids[i])...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s sqliteStateStore) History(after time.Time) ([]HistoryEntry, error) {
+	_, entries, err := historyTable.selectSince(s.db, after)
+	return entries, err
+}
+
+func (s sqliteStateStore) Activity(after time.Time) ([]ActivityEvent, error) {
+	_, events, err := activityTable.selectSince(s.db, after)
+	return events, err
+}
+
+// loadStateDocument reads the state other than its history and activity.
+func loadStateDocument(tx *sql.Tx) (State, error) {
+	var state State
+
+	var d string
+	err := tx.QueryRow("SELECT data FROM state WHERE id = 1").Scan(&d)
+	if errors.Is(err, sql.ErrNoRows) {
+		return State{}, nil
+	} else if err != nil {
+		return State{}, err
+	}
+
+	err = json.Unmarshal([]byte(d), &state)
+	if err != nil {
+		return State{}, err
+	}
+
+	return state, nil
+}
+
+// entryTable is a table of timestamped entries, oldest first. Times are
+// stored in nanoseconds.
+type entryTable[T any] struct {
+	name string
+	// columns follow the id.
+	columns []string
+	// scan reads a row of the id and columns.
+	scan func(rows *sql.Rows) (int64, T, error)
+	// values returns the columns of an entry.
+	values func(entry T) []any
+}
+
+var historyTable = entryTable[HistoryEntry]{
+	name:    "history",
+	columns: []string{"session", "path", "time"},
+	scan: func(rows *sql.Rows) (int64, HistoryEntry, error) {
+		var id, nanos int64
+		var e HistoryEntry
+		err := rows.Scan(&id, &e.Session, &e.Path, &nanos)
+		e.Time = time.Unix(0, nanos)
+		return id, e, err
+	},
+	values: func(e HistoryEntry) []any { return []any{e.Session, e.Path, e.Time.UnixNano()} },
+}
+
+var activityTable = entryTable[ActivityEvent]{
+	name:    "activity",
+	columns: []string{"event", "session", "time"},
+	scan: func(rows *sql.Rows) (int64, ActivityEvent, error) {
+		var id, nanos int64
+		var e ActivityEvent
+		err := rows.Scan(&id, &e.Event, &e.Session, &nanos)
+		e.Time = time.Unix(0, nanos)
+		return id, e, err
+	},
+	values: func(e ActivityEvent) []any { return []any{e.Event, e.Session, e.Time.UnixNano()} },
+}
+
+// querier is a database or a transaction.
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func (t entryTable[T]) selectAll(q querier) ([]int64, []T, error) {
+	return t.selectWhere(q, "")
+}
+
+// selectSince returns the entries from the last one before after onward,
+// as that one may still be going on at after.
+func (t entryTable[T]) selectSince(q querier, after time.Time) ([]int64, []T, error) {
+	where := fmt.Sprintf("WHERE time >= coalesce((SELECT max(time) FROM %s WHERE time < ?1), ?1)", t.name)
+	return t.selectWhere(q, where, after.UnixNano())
+}
+
+func (t entryTable[T]) selectWhere(q querier, where string, args ...any) ([]int64, []T, error) {
+	query := fmt.Sprintf("SELECT id, %s FROM %s %s ORDER BY id", strings.Join(t.columns, ", "), t.name, where)
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	var entries []T
+	for rows.Next() {
+		id, entry, err := t.scan(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		ids = append(ids, id)
+		entries = append(entries, entry)
+	}
+
+	return ids, entries, rows.Err()
+}
+
+// append inserts entries and deletes the oldest rows beyond keep.
+func (t entryTable[T]) append(tx *sql.Tx, entries []T, keep int) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(t.columns)), ", ")
+	insert, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", t.name, strings.Join(t.columns, ", "), placeholders))
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	for _, entry := range entries {
+		_, err = insert.Exec(t.values(entry)...)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Rows are numbered in the order they were inserted.
+	_, err = tx.Exec(fmt.Sprintf("DELETE FROM %[1]s WHERE id < (SELECT id FROM %[1]s ORDER BY id DESC LIMIT 1 OFFSET ?)", t.name), keep-1)
+	return err
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const defaultStatusTTL = 5 * time.Second
+
+type statusCacheEntry struct {
+	Line      string    `json:"line"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func getCachePath(name string) (string, error) {
+	cachePath, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(cachePath, "tsm", name), nil
+}
+
+// handleStatus prints a compact description of a session suitable for the
+// tmux status bar, e.g. "api:main [4]". The line is cached for status_ttl so
+// that the status bar can refresh frequently without querying tmux and git on
+// every interval.
+func handleStatus(config Config, args []string) error {
+	id, err := sessionArg(args)
+	if err != nil {
+		return err
+	}
+
+	ttl := defaultStatusTTL
+	if config.StatusTTL != "" {
+		ttl, err = time.ParseDuration(config.StatusTTL)
+		if err != nil {
+			return fmt.Errorf("tsm: invalid status_ttl: %w", err)
+		}
+	}
+
+	cachePath, err := getCachePath("status.json")
+	if err != nil {
+		return err
+	}
+
+	cache := map[string]statusCacheEntry{}
+	if f, err := os.ReadFile(cachePath); err == nil {
+		// A corrupt cache is simply rebuilt.
+		_ = json.Unmarshal(f, &cache)
+	}
+
+	if entry, ok := cache[id]; ok && time.Since(entry.CreatedAt) < ttl {
+		fmt.Fprintln(stdIO.Stdout, entry.Line)
+		return nil
+	}
+
+	line, err := statusLine(id)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdIO.Stdout, line)
+
+	cache[id] = statusCacheEntry{Line: line, CreatedAt: time.Now()}
+	for k, entry := range cache {
+		if time.Since(entry.CreatedAt) >= ttl {
+			delete(cache, k)
+		}
+	}
+
+	d, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(path.Dir(cachePath), 0700)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(cachePath, d, 0600)
+}
+
+func statusLine(id string) (string, error) {
+	sessions, err := listSessionDetails()
+	if err != nil {
+		return "", err
+	}
+
+	line := id
+	for _, s := range sessions {
+		if s.Name != id {
+			continue
+		}
+
+		if branch := gitBranch(s.Path); branch != "" {
+			line += ":" + branch
+		}
+	}
+
+	return fmt.Sprintf("%s [%d]", line, len(sessions)), nil
+}
+
+// gitBranch reads the checked out branch of the repository at dir directly
+// from its HEAD file to avoid forking git. Detached heads are reported as an
+// abbreviated commit hash. An empty string is returned if dir is not the root
+// of a repository.
+func gitBranch(dir string) string {
+	gitDir := path.Join(dir, ".git")
+
+	info, err := os.Stat(gitDir)
+	if err != nil {
+		return ""
+	}
+
+	// Worktrees and submodules use a .git file pointing at the real
+	// directory.
+	if !info.IsDir() {
+		f, err := os.ReadFile(gitDir)
+		if err != nil {
+			return ""
+		}
+
+		target, ok := strings.CutPrefix(strings.TrimSpace(string(f)), "gitdir: ")
+		if !ok {
+			return ""
+		}
+
+		if !path.IsAbs(target) {
+			target = path.Join(dir, target)
+		}
+		gitDir = target
+	}
+
+	head, err := os.ReadFile(path.Join(gitDir, "HEAD"))
+	if err != nil {
+		return ""
+	}
+
+	ref := strings.TrimSpace(string(head))
+	if branch, ok := strings.CutPrefix(ref, "ref: refs/heads/"); ok {
+		return branch
+	}
+
+	if len(ref) > 7 {
+		return ref[:7]
+	}
+
+	return ref
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// defaultSubMarkers mark the components of a repository unless sub.markers
+// is set.
+var defaultSubMarkers = []string{
+	"go.mod", "package.json", "Cargo.toml", "pyproject.toml", "setup.py",
+	"pom.xml", "build.gradle", "build.gradle.kts", "*.csproj",
+}
+
+// defaultSubMaxDepth bounds the search for components unless sub.max_depth
+// is set.
+const defaultSubMaxDepth = 4
+
+type SubConfig struct {
+	// Markers are file patterns, e.g. "go.mod" or "*.csproj", that make a
+	// directory of a repository one of its components.
+	Markers []string `json:"markers,omitempty"`
+	// MaxDepth bounds how deep below the repository root components are
+	// looked for. It defaults to 4.
+	MaxDepth int `json:"max_depth,omitempty"`
+	// Mode is one of the Mode constants and decides whether components get
+	// sessions or windows. It defaults to the top-level mode.
+	Mode string `json:"mode,omitempty"`
+}
+
+// handleSub lists the components of the repository holding the current
+// directory, such as the packages of a monorepo, and opens the selected one.
+// Components get a session of their own, named after the repository and the
+// component, or a window in the current session in windows mode.
+func handleSub(config Config, args []string) error {
+	flags := flag.NewFlagSet("sub", flag.ExitOnError)
+	windows := flags.Bool("window", false, "")
+	flags.Parse(args)
+
+	out, err := runCommandOutput("git", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return errors.New("tsm: sub must be run inside a git repository")
+	}
+	root := strings.TrimSpace(out)
+
+	components, err := findComponents(config, root)
+	if err != nil {
+		return err
+	} else if len(components) == 0 {
+		return errors.New("tsm: no components found; set sub.markers to the files that mark them")
+	}
+
+	// A single match of the query is opened without asking.
+	out, err = runFzf(strings.NewReader(strings.Join(components, "\n")+"\n"),
+		"--prompt", path.Base(root)+"> ", "--query", strings.Join(flags.Args(), " "), "--select-1")
+	if err != nil {
+		return err
+	}
+	rel := strings.TrimSpace(out)
+	dir := path.Join(root, rel)
+
+	if config.printTarget {
+		return printTargetDir(dir)
+	}
+
+	mode := config.Sub.Mode
+	if mode == "" {
+		mode = config.Mode
+	}
+	if *windows || mode == ModeWindows {
+		return switchToComponentWindow(config, dir)
+	}
+
+	// Sessions of components are grouped under the repository's.
+	name, _ := sessionName(config, root)
+	id, err := ensureNamedSession(config, dir, name+"-"+strings.ReplaceAll(rel, "/", "-"))
+	if err != nil || id == "" {
+		return err
+	}
+
+	return switchToSession(config, id)
+}
+
+// findComponents returns the directories below root, relative to it, that
+// contain a marker file. Ignored and hidden directories are skipped like
+// during discovery.
+func findComponents(config Config, root string) ([]string, error) {
+	markers := config.Sub.Markers
+	if len(markers) == 0 {
+		markers = defaultSubMarkers
+	}
+	maxDepth := config.Sub.MaxDepth
+	if maxDepth == 0 {
+		maxDepth = defaultSubMaxDepth
+	}
+
+	var components []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable directories are left out.
+			return fs.SkipDir
+		} else if !d.IsDir() || p == root {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if isIgnored(p, config) || d.Name() == "node_modules" || d.Name() == "vendor" {
+			return fs.SkipDir
+		}
+
+		for _, marker := range markers {
+			if matches, _ := filepath.Glob(path.Join(p, marker)); len(matches) > 0 {
+				components = append(components, rel)
+				break
+			}
+		}
+
+		if strings.Count(rel, "/")+1 >= maxDepth {
+			return fs.SkipDir
+		}
+
+		return nil
+	})
+
+	return components, err
+}
+
+// switchToComponentWindow selects the window of a component in the current
+// session, creating it if necessary.
+func switchToComponentWindow(config Config, dir string) error {
+	if !usingTmux() || !insideTmux() {
+		return errors.New("tsm: windows of components must be opened inside tmux")
+	}
+
+	session, err := currentSession()
+	if err != nil {
+		return err
+	}
+
+	windowID, err := ensureProjectWindow(config, session, dir)
+	if err != nil {
+		return err
+	}
+
+	err = runCommand(IO{}, "tmux", "select-window", "-t", windowID)
+	if err != nil {
+		return err
+	}
+
+	return switchToSession(config, session)
+}
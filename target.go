@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// validateTarget checks that a session can be created in dir, reporting why
+// not in terms clearer than tmux's own errors.
+func validateTarget(dir string) error {
+	info, err := os.Stat(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Lstat(dir); err == nil {
+			return fmt.Errorf("tsm: %s is a broken symlink", dir)
+		}
+
+		return fmt.Errorf("tsm: %s does not exist", dir)
+	} else if err != nil {
+		return fmt.Errorf("tsm: cannot access %s: %w", dir, err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("tsm: %s is not a directory", dir)
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("tsm: %s is not readable", dir)
+	}
+
+	return f.Close()
+}
+
+// forgetTarget offers to remove an invalid directory from the registered
+// projects and the pinned picker entries.
+func forgetTarget(configPath string, config Config, dir string) error {
+	if p, ok := findProject(config, dir); ok {
+		fmt.Fprintf(stdIO.Stderr, "%s is registered as a project.\n", dir)
+
+		ok, err := confirm("Unregister it?")
+		if err != nil {
+			return err
+		} else if ok {
+			err = updateConfigFile(configPath, func(config *Config) error {
+				config.Projects = slices.DeleteFunc(config.Projects, func(q ProjectConfig) bool {
+					return q.Path == p.Path
+				})
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	state, err := loadState()
+	if err != nil || !slices.Contains(state.Pins, dir) {
+		return err
+	}
+
+	ok, err := confirm("Unpin %s?", dir)
+	if err != nil || !ok {
+		return err
+	}
+
+	return updateState(func(state *State) error {
+		state.Pins = slices.DeleteFunc(state.Pins, func(p string) bool { return p == dir })
+		return nil
+	})
+}
@@ -0,0 +1,588 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Template describes the windows created alongside a new session.
+type Template struct {
+	// Extends names a template whose settings and windows this template
+	// inherits. Inherited windows come first and windows with the same name
+	// replace them.
+	Extends string `json:"extends,omitempty"`
+	// Shell overrides the shell configured for all sessions.
+	Shell string `json:"shell,omitempty"`
+	// SessionOptions are tmux options set on the session. They take
+	// precedence over the options configured for all sessions.
+	SessionOptions map[string]string `json:"session_options,omitempty"`
+	// Env sets environment variables in the session.
+	Env map[string]string `json:"env,omitempty"`
+	// Async creates the windows in the background so that switching to a
+	// new session does not wait for delays and wait_for conditions. A
+	// notification is shown once the session is set up.
+	Async bool `json:"async,omitempty"`
+	// WindowNaming selects how the windows are named. See the WindowNaming
+	// constants. It defaults to the window_naming config.
+	WindowNaming string           `json:"window_naming,omitempty"`
+	Windows      []WindowTemplate `json:"windows"`
+	// Match lists file patterns, e.g. "go.mod" or "*.csproj", that select
+	// the template for new sessions of projects containing a match.
+	Match []string `json:"match,omitempty"`
+	// OnKill lists shell commands run in the project directory before tsm
+	// kills the session, e.g. to stop services the windows started.
+	OnKill []string `json:"on_kill,omitempty"`
+	// OnKillTimeout is how long each on_kill command may run. It defaults
+	// to defaultOnKillTimeout.
+	OnKillTimeout string `json:"on_kill_timeout,omitempty"`
+}
+
+// Rules for naming the windows of a template. By default, windows named by
+// the template keep their names while tmux renames the others after the
+// program running in them.
+const (
+	// WindowNamingFixed keeps the initial name of every window.
+	WindowNamingFixed = "fixed"
+	// WindowNamingAuto has tmux rename every window after the program
+	// running in it, including windows named by the template.
+	WindowNamingAuto = "auto"
+	// WindowNamingCommand names windows without a name after the program
+	// their command runs, and keeps the name.
+	WindowNamingCommand = "command"
+)
+
+type WindowTemplate struct {
+	// Use names a shared window definition from the top level windows config
+	// that this window is based on. Fields set here override it.
+	Use     string `json:"use,omitempty"`
+	Name    string `json:"name"`
+	Command string `json:"command,omitempty"`
+	// Dir is the window's working directory. Relative paths are resolved
+	// against the project directory.
+	Dir string `json:"dir,omitempty"`
+	// Env sets environment variables in the window's panes.
+	Env map[string]string `json:"env,omitempty"`
+	// Delay postpones the window's command by a duration such as "2s".
+	Delay string `json:"delay,omitempty"`
+	// WaitFor postpones the window's command until a condition is met.
+	WaitFor *WaitFor `json:"wait_for,omitempty"`
+	// Panes are split off the window's first pane, which runs Command.
+	Panes []PaneTemplate `json:"panes,omitempty"`
+	// Layout is a tmux layout such as "main-vertical" applied once the
+	// panes are created.
+	Layout string `json:"layout,omitempty"`
+}
+
+// PaneTemplate describes an additional pane of a window. Its command starts
+// along with the window's.
+type PaneTemplate struct {
+	Command string `json:"command,omitempty"`
+	// Dir is the pane's working directory. Relative paths are resolved
+	// against the window's directory.
+	Dir string `json:"dir,omitempty"`
+	// Env sets environment variables in the pane on top of the window's.
+	Env map[string]string `json:"env,omitempty"`
+}
+
+// resolveDir resolves a template directory against base.
+func resolveDir(base, dir string) string {
+	dir = expandHome(dir)
+	if dir == "" {
+		return base
+	} else if path.IsAbs(dir) {
+		return dir
+	}
+
+	return path.Join(base, dir)
+}
+
+// mergeEnv returns the variables of both maps, preferring those in override.
+func mergeEnv(env, override map[string]string) map[string]string {
+	merged := map[string]string{}
+	for k, v := range env {
+		merged[k] = v
+	}
+	for k, v := range override {
+		merged[k] = v
+	}
+
+	return merged
+}
+
+// WaitFor is a readiness condition checked before a window's command is
+// started. Every specified condition must be satisfied.
+type WaitFor struct {
+	// Port waits until a TCP connection to Host:Port succeeds.
+	Port int    `json:"port,omitempty"`
+	Host string `json:"host,omitempty"`
+	// File waits until the path exists. Relative paths are resolved against
+	// the project directory.
+	File string `json:"file,omitempty"`
+	// Timeout bounds the wait. It defaults to 30s.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+const defaultWaitTimeout = 30 * time.Second
+
+// lookupTemplate returns the named template with its inheritance chain and
+// shared window definitions resolved.
+func lookupTemplate(config Config, name string) (Template, error) {
+	return resolveTemplate(config, name, nil)
+}
+
+func resolveTemplate(config Config, name string, seen []string) (Template, error) {
+	if slices.Contains(seen, name) {
+		return Template{}, fmt.Errorf("tsm: template %q extends itself", name)
+	}
+	seen = append(seen, name)
+
+	t, ok := config.Templates[name]
+	if !ok {
+		return Template{}, fmt.Errorf("tsm: unknown template %q", name)
+	}
+
+	windows := make([]WindowTemplate, 0, len(t.Windows))
+	for _, w := range t.Windows {
+		w, err := resolveWindow(config, w)
+		if err != nil {
+			return Template{}, fmt.Errorf("tsm: template %q: %w", name, err)
+		}
+		windows = append(windows, w)
+	}
+	t.Windows = windows
+
+	if t.Extends == "" {
+		return t, nil
+	}
+
+	parent, err := resolveTemplate(config, t.Extends, seen)
+	if err != nil {
+		return Template{}, err
+	}
+
+	return extendTemplate(parent, t), nil
+}
+
+// extendTemplate layers child onto parent.
+func extendTemplate(parent, child Template) Template {
+	t := parent
+	t.Extends = ""
+
+	if child.Shell != "" {
+		t.Shell = child.Shell
+	}
+	t.Async = parent.Async || child.Async
+	if child.WindowNaming != "" {
+		t.WindowNaming = child.WindowNaming
+	}
+	t.OnKill = append(slices.Clone(parent.OnKill), child.OnKill...)
+	if child.OnKillTimeout != "" {
+		t.OnKillTimeout = child.OnKillTimeout
+	}
+
+	t.SessionOptions = map[string]string{}
+	for k, v := range parent.SessionOptions {
+		t.SessionOptions[k] = v
+	}
+	for k, v := range child.SessionOptions {
+		t.SessionOptions[k] = v
+	}
+
+	t.Env = map[string]string{}
+	for k, v := range parent.Env {
+		t.Env[k] = v
+	}
+	for k, v := range child.Env {
+		t.Env[k] = v
+	}
+
+	t.Windows = slices.Clone(parent.Windows)
+	for _, w := range child.Windows {
+		i := slices.IndexFunc(t.Windows, func(inherited WindowTemplate) bool {
+			return w.Name != "" && inherited.Name == w.Name
+		})
+
+		if i >= 0 {
+			t.Windows[i] = w
+		} else {
+			t.Windows = append(t.Windows, w)
+		}
+	}
+
+	return t
+}
+
+// resolveWindow applies the shared window definition a window uses.
+func resolveWindow(config Config, w WindowTemplate) (WindowTemplate, error) {
+	if w.Use == "" {
+		return w, nil
+	}
+
+	shared, ok := config.Windows[w.Use]
+	if !ok {
+		return WindowTemplate{}, fmt.Errorf("unknown shared window %q", w.Use)
+	} else if shared.Use != "" {
+		return WindowTemplate{}, fmt.Errorf("shared window %q cannot use another window", w.Use)
+	}
+
+	if w.Name != "" {
+		shared.Name = w.Name
+	}
+	if w.Command != "" {
+		shared.Command = w.Command
+	}
+	if w.Delay != "" {
+		shared.Delay = w.Delay
+	}
+	if w.WaitFor != nil {
+		shared.WaitFor = w.WaitFor
+	}
+	if w.Panes != nil {
+		shared.Panes = w.Panes
+	}
+	if w.Layout != "" {
+		shared.Layout = w.Layout
+	}
+	if w.Dir != "" {
+		shared.Dir = w.Dir
+	}
+	if w.Env != nil {
+		shared.Env = mergeEnv(shared.Env, w.Env)
+	}
+
+	return shared, nil
+}
+
+// applyTemplate creates the template's windows in a freshly created session.
+// The session's initial window is reused for the first template window. All
+// windows are created before any command is started so that commands run in
+// declaration order, honoring each window's delay and readiness condition.
+func applyTemplate(id, targetDir string, t Template) error {
+	if len(t.Windows) == 0 {
+		return nil
+	}
+
+	out, err := runCommandOutput("tmux", "list-windows", "-t", id, "-F", "#{window_id}")
+	if err != nil {
+		return err
+	}
+
+	windows := splitLines(out)
+	if len(windows) == 0 {
+		return fmt.Errorf("tsm: session %q has no windows", id)
+	}
+	firstWindow := windows[0]
+
+	windowIDs := make([]string, len(t.Windows))
+	for i, w := range t.Windows {
+		windowDir := resolveDir(targetDir, w.Dir)
+
+		if i == 0 {
+			windowIDs[i] = firstWindow
+			if w.Name != "" {
+				err = runCommand(IO{}, "tmux", "rename-window", "-t", firstWindow, w.Name)
+				if err != nil {
+					return err
+				}
+			}
+
+			// The session's initial pane is restarted to apply the
+			// window's directory and environment.
+			if w.Dir != "" || len(w.Env) > 0 {
+				command := []string{"tmux", "respawn-pane", "-k", "-t", firstWindow, "-c", windowDir}
+				err = runCommand(IO{}, append(command, envArgs(w.Env)...)...)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		windowIDs[i], err = newTemplateWindow(id, targetDir, w)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = startWindows(targetDir, t, windowIDs)
+	if err != nil {
+		return err
+	}
+
+	return runCommand(IO{}, "tmux", "select-window", "-t", firstWindow)
+}
+
+// newTemplateWindow creates a window of a template in the background and
+// returns its ID.
+func newTemplateWindow(id, targetDir string, w WindowTemplate) (string, error) {
+	command := []string{"tmux", "new-window", "-d", "-t", id + ":", "-c", resolveDir(targetDir, w.Dir), "-P", "-F", "#{window_id}"}
+	command = append(command, envArgs(w.Env)...)
+	if w.Name != "" {
+		command = append(command, "-n", w.Name)
+	}
+
+	windowID, err := runCommandOutput(command...)
+	return strings.TrimSpace(windowID), err
+}
+
+// startWindows names the created windows of a template, splits them into
+// their panes, and starts their commands.
+func startWindows(targetDir string, t Template, windowIDs []string) error {
+	err := nameWindows(t, windowIDs)
+	if err != nil {
+		return err
+	}
+
+	// The first pane of each window is followed by its additional panes.
+	paneIDs := make([][]string, len(t.Windows))
+	for i, w := range t.Windows {
+		out, err := runCommandOutput("tmux", "display-message", "-p", "-t", windowIDs[i], "#{pane_id}")
+		if err != nil {
+			return err
+		}
+		paneIDs[i] = []string{strings.TrimSpace(out)}
+
+		for _, p := range w.Panes {
+			command := []string{"tmux", "split-window", "-d", "-t", windowIDs[i],
+				"-c", resolveDir(resolveDir(targetDir, w.Dir), p.Dir), "-P", "-F", "#{pane_id}"}
+			paneID, err := runCommandOutput(append(command, envArgs(mergeEnv(w.Env, p.Env))...)...)
+			if err != nil {
+				return err
+			}
+			paneIDs[i] = append(paneIDs[i], strings.TrimSpace(paneID))
+		}
+
+		if w.Layout != "" {
+			err = runCommand(IO{}, "tmux", "select-layout", "-t", windowIDs[i], w.Layout)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, w := range t.Windows {
+		commands := []string{w.Command}
+		for _, p := range w.Panes {
+			commands = append(commands, p.Command)
+		}
+
+		if !slices.ContainsFunc(commands, func(c string) bool { return c != "" }) {
+			continue
+		}
+
+		err = waitForWindow(w, targetDir)
+		if err != nil {
+			return err
+		}
+
+		for j, command := range commands {
+			if command == "" {
+				continue
+			}
+
+			err = runCommand(IO{}, "tmux", "send-keys", "-t", paneIDs[i][j], command, "Enter")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// nameWindows applies the template's window naming rule to its windows.
+func nameWindows(t Template, windowIDs []string) error {
+	for i, w := range t.Windows {
+		var command []string
+		switch t.WindowNaming {
+		case "":
+			return nil
+		case WindowNamingFixed:
+			command = []string{"tmux", "set-option", "-w", "-t", windowIDs[i], "automatic-rename", "off"}
+		case WindowNamingAuto:
+			command = []string{"tmux", "set-option", "-w", "-t", windowIDs[i], "automatic-rename", "on"}
+		case WindowNamingCommand:
+			// Renaming turns off automatic renaming of the window.
+			program := commandProgram(w.Command)
+			if w.Name != "" || program == "" {
+				continue
+			}
+			command = []string{"tmux", "rename-window", "-t", windowIDs[i], program}
+		default:
+			return fmt.Errorf("tsm: unknown window_naming %q", t.WindowNaming)
+		}
+
+		err := runCommand(IO{}, command...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// commandProgram returns the name of the program a shell command line runs,
+// skipping leading variable assignments, e.g. "npm" for "PORT=3000 npm
+// start".
+func commandProgram(command string) string {
+	for _, field := range strings.Fields(command) {
+		if !strings.Contains(field, "=") {
+			return path.Base(field)
+		}
+	}
+
+	return ""
+}
+
+func waitForWindow(w WindowTemplate, targetDir string) error {
+	if w.Delay != "" {
+		delay, err := time.ParseDuration(w.Delay)
+		if err != nil {
+			return fmt.Errorf("tsm: invalid delay for window %q: %w", w.Name, err)
+		}
+
+		time.Sleep(delay)
+	}
+
+	if w.WaitFor == nil {
+		return nil
+	}
+
+	return w.WaitFor.wait(targetDir, fmt.Sprintf("window %q", w.Name))
+}
+
+// wait blocks until the conditions are met or the timeout expires. The
+// subject names what is waited for in errors.
+func (w WaitFor) wait(targetDir, subject string) error {
+	timeout := defaultWaitTimeout
+	if w.Timeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(w.Timeout)
+		if err != nil {
+			return fmt.Errorf("tsm: invalid wait_for timeout for %s: %w", subject, err)
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	for !w.ready(targetDir) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("tsm: timed out waiting for %s", subject)
+		}
+
+		time.Sleep(250 * time.Millisecond)
+	}
+
+	return nil
+}
+
+// ready reports whether the conditions are met. Relative file paths are
+// resolved against the project directory.
+func (w WaitFor) ready(targetDir string) bool {
+	if w.Port != 0 {
+		host := w.Host
+		if host == "" {
+			host = "localhost"
+		}
+
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(w.Port)), time.Second)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+	}
+
+	if w.File != "" {
+		file := w.File
+		if !path.IsAbs(file) {
+			file = path.Join(targetDir, file)
+		}
+
+		if _, err := os.Stat(file); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// bareChoice is the entry of the template chooser that uses no template.
+const bareChoice = "(bare)"
+
+// chooseProjectTemplate returns the name of the template for a new session
+// of a project directory, or an empty string for none. A registered project's
+// template is used as is. Otherwise, the templates whose match patterns match
+// the project apply, falling back to the default template. If several
+// templates apply, or --choose-template was given, a picker asks which one to
+// use when there is a terminal to ask in and tsm is not serving requests.
+// Otherwise the default template is used if it applies, or else the first
+// that applies by name.
+func chooseProjectTemplate(config Config, targetDir string) (string, error) {
+	if p, ok := findProject(config, targetDir); ok && p.Template != "" && !config.chooseTemplate {
+		return p.Template, nil
+	}
+
+	defaultName := projectTemplate(config, targetDir)
+	candidates := matchingTemplates(config, targetDir)
+	if config.chooseTemplate {
+		candidates = make([]string, 0, len(config.Templates))
+		for name := range config.Templates {
+			candidates = append(candidates, name)
+		}
+		slices.Sort(candidates)
+	} else if len(candidates) == 0 {
+		return defaultName, nil
+	} else if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+
+	// The default template comes first if it applies.
+	if i := slices.Index(candidates, defaultName); i > 0 {
+		candidates = append([]string{defaultName}, slices.Delete(candidates, i, i+1)...)
+	}
+
+	if info, err := os.Stdin.Stat(); config.nonInteractive || err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		if !config.chooseTemplate {
+			return candidates[0], nil
+		}
+		return defaultName, nil
+	}
+
+	candidates = append(candidates, bareChoice)
+
+	out, err := runFzf(strings.NewReader(strings.Join(candidates, "\n")+"\n"),
+		"--prompt", "template> ", "--height", "40%", "--reverse")
+	if err != nil {
+		return "", err
+	}
+
+	name := strings.TrimSpace(out)
+	if name == bareChoice {
+		return "", nil
+	}
+
+	return name, nil
+}
+
+// matchingTemplates returns the names of the templates with a match pattern
+// matching a file in the project directory, in order of name.
+func matchingTemplates(config Config, targetDir string) []string {
+	var names []string
+	for name, t := range config.Templates {
+		if slices.ContainsFunc(t.Match, func(pattern string) bool {
+			matches, err := filepath.Glob(path.Join(targetDir, pattern))
+			return err == nil && len(matches) > 0
+		}) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	return names
+}
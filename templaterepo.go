@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// sharedTemplatesDir is the directory next to the config file holding the
+// template repositories synced with templates sync, one clone each.
+const sharedTemplatesDir = "templates"
+
+// handleTemplates runs the templates subcommands.
+func handleTemplates(configPath string, args []string) error {
+	if len(args) == 0 || args[0] != "sync" {
+		return errors.New("tsm: templates requires a subcommand: sync")
+	}
+
+	return handleTemplatesSync(configPath, args[1:])
+}
+
+// handleTemplatesSync clones a repository of shared templates next to the
+// config file, or pulls it if it was synced before. Without a URL, every
+// repository synced before is pulled.
+func handleTemplatesSync(configPath string, args []string) error {
+	if len(args) > 1 {
+		return errors.New("tsm: templates sync takes at most one repository")
+	}
+
+	dir := path.Join(path.Dir(configPath), sharedTemplatesDir)
+	if len(args) == 0 {
+		repos, err := sharedTemplateRepos(dir)
+		if err != nil {
+			return err
+		} else if len(repos) == 0 {
+			return errors.New("tsm: no template repositories synced yet; run: tsm templates sync URL")
+		}
+
+		for _, repo := range repos {
+			err = pullTemplateRepo(path.Join(dir, repo))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	url := args[0]
+	repoDir := path.Join(dir, templateRepoName(url))
+	if !fileExists(repoDir) {
+		err := os.MkdirAll(dir, 0700)
+		if err != nil {
+			return err
+		}
+
+		inOut := IO{Stdout: stdIO.Stderr, Stderr: stdIO.Stderr}
+		err = runCommand(inOut, "git", "clone", "--quiet", "--depth", "1", url, repoDir)
+		if err != nil {
+			return fmt.Errorf("tsm: cloning %s: %w", url, err)
+		}
+
+		return nil
+	}
+
+	// Two repositories with the same name would share a clone.
+	origin, err := runCommandOutput("git", "-C", repoDir, "remote", "get-url", "origin")
+	if err != nil {
+		return fmt.Errorf("tsm: %s is not a clone of a template repository: %w", repoDir, err)
+	} else if origin = strings.TrimSpace(origin); origin != url {
+		return fmt.Errorf("tsm: %s is already synced from %s", repoDir, origin)
+	}
+
+	return pullTemplateRepo(repoDir)
+}
+
+// pullTemplateRepo brings a synced template repository up to date. Shared
+// templates are not meant to be edited locally, so only fast-forwards are
+// accepted.
+func pullTemplateRepo(repoDir string) error {
+	inOut := IO{Stdout: stdIO.Stderr, Stderr: stdIO.Stderr}
+	err := runCommand(inOut, "git", "-C", repoDir, "pull", "--quiet", "--ff-only")
+	if err != nil {
+		return fmt.Errorf("tsm: pulling %s: %w", repoDir, err)
+	}
+
+	return nil
+}
+
+// templateRepoName derives the directory of a template repository's clone
+// from its URL, e.g. "templates" for "git@github.com:org/templates.git".
+func templateRepoName(url string) string {
+	url = strings.TrimSuffix(strings.TrimRight(url, "/"), ".git")
+	if i := strings.LastIndexAny(url, "/:"); i >= 0 {
+		url = url[i+1:]
+	}
+	if url == "" || url == "." || url == ".." {
+		return "templates"
+	}
+
+	return url
+}
+
+// sharedTemplateRepos returns the names of the synced template repositories
+// in dir in order.
+func sharedTemplateRepos(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var repos []string
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			repos = append(repos, entry.Name())
+		}
+	}
+	sort.Strings(repos)
+
+	return repos, nil
+}
+
+// loadSharedTemplates adds the templates of the synced template repositories
+// to config. Each JSON file at the top of a repository holds one template,
+// named after the file. Templates of the config win over shared ones, and
+// repositories earlier in order win over later ones. Files that cannot be
+// read are skipped and returned as warnings, so that a broken shared template
+// does not take down every other one.
+func loadSharedTemplates(configPath string, config *Config) []string {
+	return walkSharedTemplates(configPath, func(name, p string) error {
+		if _, ok := config.Templates[name]; ok {
+			return nil
+		}
+
+		t, err := readSharedTemplate(p)
+		if err != nil {
+			return err
+		}
+
+		if config.Templates == nil {
+			config.Templates = map[string]Template{}
+		}
+		config.Templates[name] = t
+		return nil
+	})
+}
+
+// walkSharedTemplates calls fn with the name and file of every shared
+// template, in the order of the repositories. Errors are returned as
+// warnings.
+func walkSharedTemplates(configPath string, fn func(name, p string) error) []string {
+	dir := path.Join(path.Dir(configPath), sharedTemplatesDir)
+	repos, err := sharedTemplateRepos(dir)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var warnings []string
+	for _, repo := range repos {
+		entries, err := os.ReadDir(path.Join(dir, repo))
+		if err != nil {
+			warnings = append(warnings, err.Error())
+			continue
+		}
+
+		for _, entry := range entries {
+			name, ok := strings.CutSuffix(entry.Name(), ".json")
+			if !ok || entry.IsDir() {
+				continue
+			}
+
+			p := path.Join(dir, repo, entry.Name())
+			err = fn(name, p)
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf("%s: %v", p, err))
+			}
+		}
+	}
+
+	return warnings
+}
+
+func readSharedTemplate(p string) (Template, error) {
+	f, err := os.ReadFile(p)
+	if err != nil {
+		return Template{}, err
+	}
+
+	var t Template
+	dec := json.NewDecoder(bytes.NewReader(f))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&t)
+
+	return t, err
+}
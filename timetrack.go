@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// maxActivity is the number of client events kept in the state file.
+const maxActivity = 20000
+
+// defaultIdleCap bounds a single interval of activity so that a client left
+// attached overnight is not counted as work.
+const defaultIdleCap = 2 * time.Hour
+
+// ActivityEvent is a client attaching to or detaching from a session as
+// reported by the tmux hooks installed with "tsm time install".
+type ActivityEvent struct {
+	Event   string    `json:"event"`
+	Session string    `json:"session"`
+	Time    time.Time `json:"time"`
+}
+
+const (
+	eventAttach = "attach"
+	eventDetach = "detach"
+)
+
+func handleTime(config Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("tsm: time requires a subcommand (install, record, report)")
+	}
+
+	switch args[0] {
+	case "install":
+		return installTimeHooks()
+	case "record":
+		return handleTimeRecord(args[1:])
+	case "report":
+		return handleTimeReport(config, args[1:])
+	default:
+		return fmt.Errorf("tsm: unknown time subcommand %q", args[0])
+	}
+}
+
+// installTimeHooks sets global tmux hooks that record client activity. The
+// hooks are lost when the tmux server exits, so this is best run from
+// .tmux.conf.
+func installTimeHooks() error {
+	hooks := map[string]string{
+		"client-attached":        eventAttach,
+		"client-session-changed": eventAttach,
+		"client-detached":        eventDetach,
+	}
+
+	for hook, event := range hooks {
+		command := fmt.Sprintf(`run-shell -b "tsm time record %s '#{session_name}'"`, event)
+		err := runCommand(IO{}, "tmux", "set-hook", "-g", hook, command)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func handleTimeRecord(args []string) error {
+	if len(args) != 2 || (args[0] != eventAttach && args[0] != eventDetach) {
+		return errors.New("tsm: time record requires an event (attach or detach) and a session")
+	}
+
+	return updateState(func(state *State) error {
+		state.Activity = append(state.Activity, ActivityEvent{
+			Event:   args[0],
+			Session: args[1],
+			Time:    time.Now(),
+		})
+		return nil
+	})
+}
+
+// handleTimeReport sums the time spent in each session. Client activity
+// recorded by the tmux hooks is used when available, otherwise switches made
+// through tsm approximate it.
+func handleTimeReport(config Config, args []string) error {
+	flags := flag.NewFlagSet("time report", flag.ExitOnError)
+	week := flags.Bool("week", false, "")
+	since := flags.String("since", "", "")
+	flags.Parse(args)
+
+	var after time.Time
+	if *week {
+		now := time.Now()
+		daysSinceMonday := (int(now.Weekday()) + 6) % 7
+		after = time.Date(now.Year(), now.Month(), now.Day()-daysSinceMonday, 0, 0, 0, 0, now.Location())
+	} else if *since != "" {
+		var err error
+		after, err = parseSince(*since)
+		if err != nil {
+			return err
+		}
+	}
+
+	idleCap := defaultIdleCap
+	if config.IdleCap != "" {
+		var err error
+		idleCap, err = time.ParseDuration(config.IdleCap)
+		if err != nil {
+			return fmt.Errorf("tsm: invalid idle_cap: %w", err)
+		}
+	}
+
+	store, err := openStateStore()
+	if err != nil {
+		return err
+	}
+
+	events, err := store.Activity(after)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		history, err := store.History(after)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range history {
+			events = append(events, ActivityEvent{Event: eventAttach, Session: entry.Session, Time: entry.Time})
+		}
+	}
+
+	totals := sessionDurations(events, after, time.Now(), idleCap)
+
+	names := make([]string, 0, len(totals))
+	var total time.Duration
+	for name, d := range totals {
+		names = append(names, name)
+		total += d
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if totals[names[i]] != totals[names[j]] {
+			return totals[names[i]] > totals[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	width := len("total")
+	for _, name := range names {
+		width = max(width, len(name))
+	}
+
+	for _, name := range names {
+		fmt.Fprintf(stdIO.Stdout, "%-*s  %s\n", width, name, formatHours(totals[name]))
+	}
+	fmt.Fprintf(stdIO.Stdout, "%-*s  %s\n", width, "total", formatHours(total))
+
+	return nil
+}
+
+// formatHours formats a duration as hours and minutes, e.g. "3h07m".
+func formatHours(d time.Duration) string {
+	minutes := int(d.Round(time.Minute) / time.Minute)
+	return fmt.Sprintf("%dh%02dm", minutes/60, minutes%60)
+}
+
+// sessionDurations attributes the time between consecutive events to the
+// session attached by the earlier event. Each interval is clipped to the
+// report window and capped at idleCap.
+func sessionDurations(events []ActivityEvent, after, now time.Time, idleCap time.Duration) map[string]time.Duration {
+	events = slices.Clone(events)
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+
+	totals := map[string]time.Duration{}
+	for i, event := range events {
+		if event.Event != eventAttach {
+			continue
+		}
+
+		end := now
+		if i+1 < len(events) {
+			end = events[i+1].Time
+		}
+
+		start := event.Time
+		if start.Before(after) {
+			start = after
+		}
+
+		d := min(end.Sub(start), idleCap)
+		if d > 0 {
+			totals[event.Session] += d
+		}
+	}
+
+	return totals
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// tmpPrefix starts the name of every directory created by tmp, guarding
+// against removing anything else when its session is gone.
+const tmpPrefix = "tsm-"
+
+// TempDir is a scratch directory created for a session by tmp.
+type TempDir struct {
+	Session string    `json:"session"`
+	Path    string    `json:"path"`
+	Created time.Time `json:"created"`
+}
+
+// handleTmp creates a session in a new temporary directory and switches to
+// it. The directory is removed by state gc once the session no longer runs.
+func handleTmp(config Config, args []string) error {
+	if len(args) > 1 {
+		return errors.New("tsm: tmp accepts at most one session name")
+	}
+
+	name := "tmp"
+	if len(args) == 1 {
+		name = args[0]
+	}
+
+	id := cleanID(config, name)
+	if sessionExists(id) {
+		id = nextFreeID(id)
+	}
+
+	dir, err := os.MkdirTemp("", tmpPrefix+id+"-")
+	if err != nil {
+		return err
+	}
+
+	// tmux reports pane paths with symlinks resolved, e.g. /private/var on
+	// macOS, so the resolved path is recorded to match them.
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+
+	err = updateState(func(state *State) error {
+		state.Temp = append(state.Temp, TempDir{Session: id, Path: dir, Created: time.Now()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = createProjectSession(config, id, dir)
+	if err != nil {
+		return err
+	}
+
+	return switchToSession(config, id)
+}
+
+// cleanTempDirs removes the temporary directories whose sessions are no
+// longer running and returns their paths. In a dry run, they are only listed.
+func cleanTempDirs(dryRun bool) ([]string, error) {
+	state, err := loadState()
+	if err != nil || len(state.Temp) == 0 {
+		return nil, err
+	}
+
+	// Without a tmux server, no session is running.
+	sessions, _ := listSessionDetails()
+	running := func(dir string) bool {
+		return slices.ContainsFunc(sessions, func(s Session) bool { return path.Clean(s.Path) == dir })
+	}
+
+	var dead []string
+	for _, t := range state.Temp {
+		if running(t.Path) {
+			continue
+		}
+
+		if dryRun {
+			fmt.Fprintf(stdIO.Stdout, "%s (temporary directory of %q)\n", t.Path, t.Session)
+		} else if strings.HasPrefix(path.Base(t.Path), tmpPrefix) {
+			err = os.RemoveAll(t.Path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		dead = append(dead, t.Path)
+	}
+
+	if dryRun || len(dead) == 0 {
+		return dead, nil
+	}
+
+	err = updateState(func(state *State) error {
+		state.Temp = slices.DeleteFunc(state.Temp, func(t TempDir) bool {
+			return slices.Contains(dead, t.Path)
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(stdIO.Stdout, "Removed %s\n", plural(len(dead), "temporary directory", "temporary directories"))
+
+	return dead, nil
+}
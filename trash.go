@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const defaultUndoGrace = 10 * time.Minute
+
+// TrashEntry is the snapshot of a session taken just before tsm killed it.
+type TrashEntry struct {
+	Snapshot Snapshot  `json:"snapshot"`
+	KilledAt time.Time `json:"killed_at"`
+}
+
+func getTrashPath() (string, error) {
+	return getStatePath("trash.json")
+}
+
+func readTrash(trashPath string) ([]TrashEntry, error) {
+	f, err := os.ReadFile(trashPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var trash []TrashEntry
+	err = json.Unmarshal(f, &trash)
+	if err != nil {
+		return nil, err
+	}
+
+	return trash, nil
+}
+
+func writeTrash(trashPath string, trash []TrashEntry) error {
+	d, err := json.Marshal(trash)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(trashPath, d, 0600)
+}
+
+func undoGrace(config Config) (time.Duration, error) {
+	if config.UndoGrace == "" {
+		return defaultUndoGrace, nil
+	}
+
+	grace, err := time.ParseDuration(config.UndoGrace)
+	if err != nil {
+		return 0, fmt.Errorf("tsm: invalid undo_grace: %w", err)
+	}
+
+	return grace, nil
+}
+
+// trashSession snapshots a session's layout and then kills it. Entries older
+// than the undo grace period are discarded.
+func trashSession(config Config, id string) error {
+	// Snapshots are taken of tmux sessions only, so other sessions are
+	// killed for good.
+	if !usingTmux() {
+		sessionDir, err := sessionPath(id)
+		if err != nil {
+			return err
+		}
+		runKillHooks(config, id, sessionDir)
+
+		err = killSession(id)
+		if err != nil {
+			return err
+		}
+		recordEvent(EventKilled, id, sessionDir)
+
+		return nil
+	}
+
+	grace, err := undoGrace(config)
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := takeSnapshot(id)
+	if err != nil {
+		return err
+	}
+
+	trashPath, err := getTrashPath()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(trashPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	trash, err := readTrash(trashPath)
+	if err != nil {
+		return err
+	}
+
+	var kept []TrashEntry
+	for _, entry := range trash {
+		if time.Since(entry.KilledAt) < grace {
+			kept = append(kept, entry)
+		}
+	}
+	kept = append(kept, TrashEntry{Snapshot: snapshot, KilledAt: time.Now()})
+
+	err = writeTrash(trashPath, kept)
+	if err != nil {
+		return err
+	}
+
+	runKillHooks(config, id, snapshot.Path)
+
+	err = killSession(id)
+	if err != nil {
+		return err
+	}
+	recordEvent(EventKilled, id, snapshot.Path)
+
+	return nil
+}
+
+func handleKill(config Config, args []string) error {
+	id, err := sessionArg(args)
+	if err != nil {
+		return err
+	}
+
+	if !sessionExists(id) {
+		return fmt.Errorf("tsm: session %q does not exist", id)
+	}
+
+	ok, err := confirmUnlocked(id, "Kill")
+	if err != nil || !ok {
+		return err
+	}
+
+	return trashSession(config, id)
+}
+
+// handleUndo recreates the most recently killed session if it was killed
+// within the grace period. The session is recreated under a new name if its
+// original name has since been reused.
+func handleUndo(config Config) error {
+	grace, err := undoGrace(config)
+	if err != nil {
+		return err
+	}
+
+	trashPath, err := getTrashPath()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(trashPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	trash, err := readTrash(trashPath)
+	if err != nil {
+		return err
+	}
+
+	if len(trash) == 0 || time.Since(trash[len(trash)-1].KilledAt) >= grace {
+		return errors.New("tsm: nothing to undo")
+	}
+
+	entry := trash[len(trash)-1]
+	if sessionExists(entry.Snapshot.Name) {
+		entry.Snapshot.Name = nextFreeID(entry.Snapshot.Name)
+	}
+
+	err = restoreSnapshot(entry.Snapshot)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdIO.Stdout, "Restored session %q\n", entry.Snapshot.Name)
+
+	return writeTrash(trashPath, trash[:len(trash)-1])
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Keys of the ui in addition to the picker's kill, rename, and pin keys.
+const (
+	uiKeyTemplate = "ctrl-t"
+	uiKeySessions = "ctrl-s"
+	uiKeyProjects = "ctrl-a"
+)
+
+// Views of the ui.
+const (
+	uiViewProjects = "projects"
+	uiViewSessions = "sessions"
+)
+
+// uiRecentSwitches is the number of switches shown in the details of a
+// project.
+const uiRecentSwitches = 5
+
+// handleUI opens a full-screen browser of projects and sessions with a
+// details pane. Unlike the picker, it stays open while sessions are killed,
+// renamed, pinned, or given a template, and lists either all projects or
+// only running sessions. fzf runs the ui and calls back into tsm through the
+// hidden list, detail, and action commands.
+func handleUI(config Config, args []string) error {
+	if len(args) > 0 {
+		return handleUICommand(config, args)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	tsm := shellQuote(exe)
+
+	// The view is kept in a file since fzf reloads the list in a new
+	// process after every action.
+	viewFile, err := os.CreateTemp("", "tsm-ui-")
+	if err != nil {
+		return err
+	}
+	viewFile.Close()
+	defer os.Remove(viewFile.Name())
+
+	list := tsm + " ui list " + shellQuote(viewFile.Name())
+	action := func(key string) string {
+		return fmt.Sprintf("%s:execute(%s ui action %s {1})+reload(%s)", key, tsm, key, list)
+	}
+
+	header := strings.Join([]string{
+		"enter: switch", pickerKeyKill + ": kill", pickerKeyRename + ": rename", pickerKeyPin + ": pin",
+		uiKeyTemplate + ": template", uiKeySessions + ": sessions", uiKeyProjects + ": projects",
+	}, "  ")
+
+	var lines bytes.Buffer
+	err = writeUIList(config, &lines, uiViewProjects)
+	if err != nil {
+		return err
+	}
+
+	out, err := runFzf(&lines,
+		"--delimiter", "\t", "--with-nth", "2..", "--no-sort",
+		"--header", header, "--prompt", uiViewProjects+"> ",
+		"--preview", tsm+" ui detail {1}", "--preview-window", "right,50%,wrap",
+		"--bind", action(pickerKeyKill),
+		"--bind", action(pickerKeyRename),
+		"--bind", action(pickerKeyPin),
+		"--bind", action(uiKeyTemplate),
+		"--bind", fmt.Sprintf("%s:reload(%s %s)+change-prompt(%s> )", uiKeySessions, list, uiViewSessions, uiViewSessions),
+		"--bind", fmt.Sprintf("%s:reload(%s %s)+change-prompt(%s> )", uiKeyProjects, list, uiViewProjects, uiViewProjects),
+	)
+	if err != nil {
+		return err
+	}
+
+	targetDir, _, _ := strings.Cut(out, "\t")
+	if targetDir == "" {
+		return nil
+	}
+
+	return switchToProject(config, targetDir)
+}
+
+func handleUICommand(config Config, args []string) error {
+	switch {
+	case args[0] == "list" && (len(args) == 2 || len(args) == 3):
+		// A view given with the file switches to it.
+		view := uiViewProjects
+		if len(args) == 3 {
+			view = args[2]
+			err := os.WriteFile(args[1], []byte(view), 0600)
+			if err != nil {
+				return err
+			}
+		} else if d, err := os.ReadFile(args[1]); err == nil && len(d) > 0 {
+			view = string(d)
+		}
+
+		return writeUIList(config, stdIO.Stdout, view)
+	case args[0] == "detail" && len(args) == 2:
+		return writeUIDetail(config, args[1])
+	case args[0] == "action" && len(args) == 3:
+		err := uiAction(config, args[1], args[2])
+		if err != nil {
+			// The list is reloaded right after the action, which
+			// would hide the error.
+			fmt.Fprintln(stdIO.Stderr, err)
+			_, _ = prompt("Press enter to continue")
+		}
+		return nil
+	default:
+		return errors.New("tsm: ui takes no arguments")
+	}
+}
+
+// writeUIList writes the lines of a view of the ui. Each line is the path,
+// which is hidden, followed by the name, status, and path.
+func writeUIList(config Config, w io.Writer, view string) error {
+	var projects []Project
+	switch view {
+	case uiViewProjects:
+		var err error
+		projects, err = listProjects(config)
+		if err != nil {
+			return err
+		}
+	case uiViewSessions:
+		sessions, err := listSessionDetails()
+		if err != nil {
+			// Without a tmux server, no session is running.
+			return nil
+		}
+
+		activity := sessionActivity()
+		for _, s := range sessions {
+			p := Project{Name: s.Name, Path: s.Path, Running: true}
+			if t, ok := activity[path.Clean(s.Path)]; ok {
+				p.Activity = &t
+			}
+			projects = append(projects, p)
+		}
+
+		// The most recently used sessions come first.
+		sort.SliceStable(projects, func(i, j int) bool {
+			a, b := projects[i].Activity, projects[j].Activity
+			return a != nil && (b == nil || a.After(*b))
+		})
+	default:
+		return fmt.Errorf("tsm: unknown ui view %q", view)
+	}
+
+	nameWidth := 0
+	for _, p := range projects {
+		nameWidth = max(nameWidth, utf8.RuneCountInString(p.Name))
+	}
+
+	const statusWidth = len("idle 999d")
+	now := time.Now()
+	for _, p := range projects {
+		status := ""
+		if p.Activity != nil {
+			status = idleStatus(now.Sub(*p.Activity))
+		} else if p.Running {
+			status = "running"
+		}
+
+		fmt.Fprintf(w, "%s\t%-*s  %-*s  %s\n", p.Path, nameWidth, p.Name, statusWidth, status, p.Path)
+	}
+
+	return nil
+}
+
+// writeUIDetail prints the details of a project: its session, git state,
+// uncommitted changes, and the most recent switches to it.
+func writeUIDetail(config Config, dir string) error {
+	err := previewProject(config, dir)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := timeoutContext(config, TimeoutGit)
+	defer cancel()
+
+	if status, err := gitOutput(ctx, dir, "status", "--short"); err == nil && status != "" {
+		fmt.Fprintf(stdIO.Stdout, "\nchanges\n%s\n", status)
+	}
+
+	state, err := loadState()
+	if err != nil {
+		return err
+	}
+
+	var recent []time.Time
+	for i := len(state.History) - 1; i >= 0 && len(recent) < uiRecentSwitches; i-- {
+		if path.Clean(state.History[i].Path) == path.Clean(dir) {
+			recent = append(recent, state.History[i].Time)
+		}
+	}
+
+	if len(recent) > 0 {
+		fmt.Fprintln(stdIO.Stdout, "\nrecent switches")
+		for _, t := range recent {
+			fmt.Fprintf(stdIO.Stdout, "  %s\n", t.Local().Format("2006-01-02 15:04"))
+		}
+	}
+
+	return nil
+}
+
+// uiAction performs the action bound to key on the project at dir.
+func uiAction(config Config, key, dir string) error {
+	if key != uiKeyTemplate {
+		return handlePickerAction(config, key, dir)
+	}
+
+	names := make([]string, 0, len(config.Templates))
+	for name := range config.Templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(names) == 0 {
+		return errors.New("tsm: no templates are configured")
+	}
+
+	name, err := prompt("Template (%s): ", strings.Join(names, ", "))
+	if err != nil || name == "" {
+		return err
+	}
+
+	return handleRunTemplate(config, []string{name, dir})
+}
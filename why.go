@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+)
+
+// handleWhy explains how tsm treats a project directory, by default the
+// current one: how it is discovered and which ignore rules were evaluated,
+// how its session is named, which template a new session gets, and which
+// hooks run for it.
+func handleWhy(config Config, args []string) error {
+	if len(args) > 1 {
+		return errors.New("tsm: why takes at most one project")
+	}
+
+	arg := "."
+	if len(args) == 1 {
+		arg = args[0]
+	}
+
+	dir, err := resolveProject(config, arg)
+	if err != nil {
+		return err
+	}
+
+	w := stdIO.Stdout
+	fmt.Fprintln(w, dir)
+
+	fmt.Fprintln(w, "\ndiscovery")
+	explainDiscovery(w, config, dir)
+
+	fmt.Fprintln(w, "\nname")
+	explainName(w, config, dir)
+
+	fmt.Fprintln(w, "\ntemplate")
+	t, name, err := explainTemplate(w, config, dir)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "\nhooks")
+	explainHooks(w, config, dir, t, name)
+
+	return nil
+}
+
+// explainDiscovery prints how the picker finds dir, following the order of
+// visitDirectories.
+func explainDiscovery(w io.Writer, config Config, dir string) {
+	if _, ok := findProject(config, dir); ok {
+		fmt.Fprintln(w, "  registered in projects, which are never ignored")
+		return
+	}
+
+	var origin string
+	parent := canonicalPath(path.Dir(dir))
+	for _, baseDir := range config.BaseDirs {
+		if origin == "" && canonicalPath(expandHome(baseDir)) == parent {
+			origin = "base dir " + baseDir
+			fmt.Fprintf(w, "  base dir %s: contains it\n", baseDir)
+		} else {
+			fmt.Fprintf(w, "  base dir %s: does not contain it\n", baseDir)
+		}
+	}
+
+	if slices.Contains(config.Sources, SourceGHQ) {
+		if ghqName(dir) != "" {
+			if origin == "" {
+				origin = "the ghq source"
+			}
+			fmt.Fprintln(w, "  ghq source: lists it")
+		} else {
+			fmt.Fprintln(w, "  ghq source: does not list it")
+		}
+	}
+
+	var ignoredBy string
+	if !config.ShowHidden {
+		hidden := strings.HasPrefix(path.Base(dir), ".")
+		fmt.Fprintf(w, "  hidden directories (show_hidden is off): %s\n", matchWord(hidden))
+		if hidden {
+			ignoredBy = "as a hidden directory"
+		}
+	}
+	for _, rule := range config.IgnoreDirs {
+		matches := ignoreRuleMatches(rule, dir)
+		fmt.Fprintf(w, "  ignore_dirs %q: %s\n", rule, matchWord(matches))
+		if matches && ignoredBy == "" {
+			ignoredBy = fmt.Sprintf("by ignore_dirs %q", rule)
+		}
+	}
+
+	switch {
+	case origin == "":
+		fmt.Fprintln(w, "  not listed; register it with tsm add or add its parent to base_dirs")
+	case ignoredBy != "":
+		fmt.Fprintf(w, "  found in %s but ignored %s\n", origin, ignoredBy)
+	default:
+		fmt.Fprintf(w, "  listed from %s\n", origin)
+	}
+}
+
+func matchWord(matches bool) string {
+	if matches {
+		return "matches"
+	}
+
+	return "no match"
+}
+
+// explainName prints the session name of dir and how it was derived.
+func explainName(w io.Writer, config Config, dir string) {
+	name, rule := sessionName(config, dir)
+	id := cleanID(config, name)
+	fmt.Fprintf(w, "  %s, from the %s\n", id, rule)
+	if id != name {
+		mode := config.Sanitize.Mode
+		if mode == "" {
+			mode = SanitizeReplace
+		}
+		fmt.Fprintf(w, "  sanitized from %q (sanitize mode %s)\n", name, mode)
+	}
+
+	if running, ok := findSessionForPath(dir); ok {
+		fmt.Fprintf(w, "  session %s is running for it\n", running)
+	} else if sessionExists(id) {
+		fmt.Fprintf(w, "  session %s belongs to another directory; on_conflict decides what happens\n", id)
+	}
+}
+
+// explainTemplate prints the template a new session of dir gets, following
+// chooseProjectTemplate, and returns it with its name.
+func explainTemplate(w io.Writer, config Config, dir string) (Template, string, error) {
+	var name string
+	if p, ok := findProject(config, dir); ok && p.Template != "" {
+		name = p.Template
+		fmt.Fprintf(w, "  %s, the template of the registered project\n", name)
+	} else if matches := matchingTemplates(config, dir); len(matches) == 1 {
+		name = matches[0]
+		fmt.Fprintf(w, "  %s, whose match patterns match\n", name)
+	} else if len(matches) > 1 {
+		name = matches[0]
+		fmt.Fprintf(w, "  picked when the session is created from %s, whose match patterns match; %s without a terminal\n",
+			strings.Join(matches, ", "), name)
+	} else if config.DefaultTemplate != "" {
+		name = config.DefaultTemplate
+		fmt.Fprintf(w, "  %s, the default_template\n", name)
+	} else {
+		fmt.Fprintln(w, "  none; the session starts with a single window")
+	}
+
+	if id, ok := findSessionForPath(dir); ok {
+		if out, err := runCommandOutput("tmux", "show-option", "-qv", "-t", id, templateOption); err == nil && strings.TrimSpace(out) != "" {
+			name = strings.TrimSpace(out)
+			fmt.Fprintf(w, "  session %s was created from %s\n", id, name)
+		}
+	}
+
+	if name == "" {
+		return Template{}, "", nil
+	}
+
+	t, err := lookupTemplate(config, name)
+	if err != nil {
+		return Template{}, "", err
+	}
+
+	for _, window := range t.Windows {
+		window, err = resolveWindow(config, window)
+		if err != nil {
+			return Template{}, "", err
+		}
+
+		fmt.Fprintf(w, "  window %q", window.Name)
+		if window.Command != "" {
+			fmt.Fprintf(w, " runs %s", window.Command)
+		}
+		fmt.Fprintln(w)
+	}
+
+	// A broken .tsm.yaml is worth explaining rather than failing on.
+	conf, err := projectTmuxConf(dir)
+	if err != nil {
+		fmt.Fprintf(w, "  %v\n", err)
+	} else if conf != "" {
+		fmt.Fprintf(w, "  sources %s\n", conf)
+	}
+
+	return t, name, nil
+}
+
+// explainHooks prints the commands run when tsm kills the session of dir.
+func explainHooks(w io.Writer, config Config, dir string, t Template, name string) {
+	var p ProjectConfig
+	if project, ok := findProject(config, dir); ok {
+		p = project
+	}
+
+	if len(t.OnKill)+len(p.OnKill) == 0 {
+		fmt.Fprintln(w, "  none")
+		return
+	}
+
+	timeout := t.OnKillTimeout
+	if timeout == "" {
+		timeout = defaultOnKillTimeout.String()
+	}
+	for _, command := range t.OnKill {
+		fmt.Fprintf(w, "  on_kill of template %s: %s\n", name, command)
+	}
+	for _, command := range p.OnKill {
+		fmt.Fprintf(w, "  on_kill of the registered project: %s\n", command)
+	}
+	fmt.Fprintf(w, "  each stopped after %s\n", timeout)
+}
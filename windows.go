@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Modes in which tsm organizes projects.
+const (
+	// ModeSessions creates a session per project.
+	ModeSessions = "sessions"
+	// ModeWindows creates a window per project in a single session.
+	ModeWindows = "windows"
+)
+
+// defaultModeSession is the session holding the project windows in
+// windows mode.
+const defaultModeSession = "tsm"
+
+// switchToProject switches to the session of a project directory, or to its
+// window when projects are organized as windows.
+func switchToProject(config Config, targetDir string) error {
+	if config.Mode == ModeWindows {
+		return switchToProjectWindow(config, targetDir)
+	}
+
+	id, err := ensureSession(config, targetDir)
+	if err != nil {
+		return err
+	} else if id == "" {
+		return nil
+	}
+
+	return switchToSession(config, id)
+}
+
+// switchToProjectWindow selects the window of a project directory in the
+// mode session, creating either if necessary, and switches to the session.
+func switchToProjectWindow(config Config, targetDir string) error {
+	err := validateTarget(targetDir)
+	if err != nil {
+		return err
+	}
+
+	session := config.ModeSession
+	if session == "" {
+		session = defaultModeSession
+	}
+
+	// Invocations for the same project take turns, so that it gets one
+	// window.
+	unlock, err := lockProject(targetDir)
+	if err != nil {
+		return err
+	}
+	windowID, err := ensureProjectWindow(config, session, targetDir)
+	unlock()
+	if err != nil {
+		return err
+	}
+
+	// History and fetching are best effort and must not prevent switching.
+	_ = recordSwitch(sessionID(config, targetDir), targetDir)
+	_ = fetchProject(config, targetDir)
+
+	err = runCommand(IO{}, "tmux", "select-window", "-t", windowID)
+	if err != nil {
+		return err
+	}
+	followMirrors(session)
+
+	if config.SpawnTerminal {
+		return spawnTerminal(config, session)
+	}
+
+	if insideTmux() {
+		return switchSession(session)
+	}
+
+	return attachToSession(session)
+}
+
+// ensureProjectWindow returns the window of a project directory in session,
+// creating the window, and the session with it, if it does not exist. Windows
+// are named like sessions and remember their project in pathOption.
+func ensureProjectWindow(config Config, session, targetDir string) (string, error) {
+	if sessionExists(session) {
+		out, err := runCommandOutput("tmux", "list-windows", "-t", session,
+			"-F", tmuxFormat("#{window_id}", "#{"+pathOption+"}"))
+		if err != nil {
+			return "", err
+		}
+
+		for _, line := range splitLines(out) {
+			windowID, windowDir, ok := strings.Cut(line, fieldSep)
+			if ok && windowDir != "" && path.Clean(windowDir) == path.Clean(targetDir) {
+				return windowID, nil
+			}
+		}
+	}
+
+	name := sessionID(config, targetDir)
+
+	var command []string
+	created := !sessionExists(session)
+	if created {
+		err := checkSessionName(session)
+		if err != nil {
+			return "", err
+		}
+
+		command = append([]string{"tmux", "new-session", "-d", "-s", session}, config.NewSessionArgs...)
+	} else {
+		command = []string{"tmux", "new-window", "-d", "-t", session + ":"}
+	}
+	command = append(command, "-n", name, "-c", targetDir, "-P", "-F", "#{window_id}")
+	if config.Shell != "" {
+		command = append(command, config.Shell)
+	}
+
+	out, err := runCommandOutput(command...)
+	if err != nil {
+		return "", err
+	}
+	windowID := strings.TrimSpace(out)
+
+	err = runCommand(IO{}, "tmux", "set-option", "-w", "-t", windowID, pathOption, targetDir)
+	if err != nil {
+		return "", err
+	}
+	recordEvent(EventCreated, name, targetDir)
+
+	if !created {
+		return windowID, nil
+	}
+
+	err = setSessionOptions(session, config.SessionOptions)
+	if err != nil {
+		return "", fmt.Errorf("tsm: setting options of session %q: %w", session, err)
+	}
+
+	if config.Shell == "" {
+		return windowID, nil
+	}
+
+	return windowID, runCommand(IO{}, "tmux", "set-option", "-t", session, "default-command", config.Shell)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+func handleWorkspace(configPath string, config Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("tsm: workspace requires a subcommand (up, list)")
+	}
+
+	switch args[0] {
+	case "up":
+		return handleWorkspaceUp(configPath, config, args[1:])
+	case "list":
+		names := make([]string, 0, len(config.Workspaces))
+		for name := range config.Workspaces {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			fmt.Fprintln(stdIO.Stdout, name)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("tsm: unknown workspace subcommand %q", args[0])
+	}
+}
+
+// handleWorkspaceUp brings up a workspace defined in the config. Relative
+// project directories are resolved against the config file's directory.
+func handleWorkspaceUp(configPath string, config Config, args []string) error {
+	flags := flag.NewFlagSet("workspace up", flag.ExitOnError)
+	prune := flags.Bool("prune", false, "")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return errors.New("tsm: workspace up requires a workspace name")
+	}
+
+	workspace, ok := config.Workspaces[flags.Arg(0)]
+	if !ok {
+		return fmt.Errorf("tsm: unknown workspace %q", flags.Arg(0))
+	}
+
+	return upManifest(config, workspace, filepath.Dir(configPath), *prune)
+}